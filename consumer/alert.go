@@ -6,120 +6,347 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
-// AlertState stores information about the last alert sent
+// Statuses of an alert
+const (
+	AlertPending  = "pending"
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+	// AlertStale resolves a composite alert whose inputs stopped reporting
+	AlertStale = "stale"
+)
+
+// ActiveAlert is a rule that is currently pending or firing for one sensor. A pending alert
+// waits for its condition to hold for the rule's duration; PendingSince and LastSeen, the
+// latest reading that met the condition, are reading timestamps.
+type ActiveAlert struct {
+	Rule         string    `json:"rule"`
+	Status       string    `json:"status"`
+	SensorID     string    `json:"sensorId"`
+	Tenant       string    `json:"tenant,omitempty"`
+	Location     string    `json:"location"`
+	Value        float64   `json:"value"`
+	Change       float64   `json:"change,omitempty"`
+	PendingSince time.Time `json:"pendingSince"`
+	LastSeen     time.Time `json:"lastSeen,omitempty"`
+	FiredAt      time.Time `json:"firedAt"`
+	LastNotified time.Time `json:"lastNotified"`
+}
+
+// AlertState stores the alerts that are pending or firing, keyed by rule and sensor
 type AlertState struct {
-	LastAlertTime time.Time `json:"lastAlertTime"`
+	Alerts map[string]*ActiveAlert `json:"alerts"`
 }
 
-// shouldSendAlert checks if we should send an alert based on the last alert time
-func (c *DataConsumer) shouldSendAlert() bool {
-	// Create directory for alert state file if it doesn't exist
-	dir := filepath.Dir(c.alertStateFile)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		log.Printf("Failed to create directory for alert state file: %v", err)
-		return true // Send alert if we can't check the state
-	}
+// alertKey identifies the alert of a rule for one sensor
+func alertKey(rule *AlertRule, data SensorData) string {
+	return rule.Name + "|" + sensorKey(data)
+}
 
-	// Check if alert state file exists
-	state, err := c.loadAlertState()
-	if err != nil {
-		log.Printf("Failed to load alert state: %v", err)
-		return true // Send alert if we can't load previous state
+// observe returns the value a rule looks at for a reading: the reading's value, or for rate
+// rules the change over the rule's window. Reports false when a rate can't be computed yet.
+func (c *DataConsumer) observe(rule *AlertRule, data SensorData) (float64, bool) {
+	if !rule.IsRate() {
+		return data.Value, true
 	}
+	if c.rates == nil {
+		return 0, false
+	}
+	return c.rates.Change(data, rule.Window)
+}
 
-	// Check if 24 hours have passed since the last alert
-	now := time.Now()
-	if now.Sub(state.LastAlertTime) < 24*time.Hour {
-		log.Printf("Alert already sent today at %v, not sending again", state.LastAlertTime)
+// evaluateAlert evaluates a single-sensor rule on a reading of one of its sensors. Reports
+// whether the alert state changed.
+func (c *DataConsumer) evaluateAlert(rule *AlertRule, data SensorData, now time.Time) bool {
+	observed, ok := c.observe(rule, data)
+	if !ok {
 		return false
 	}
+	candidate := &ActiveAlert{
+		Rule:     rule.Name,
+		SensorID: data.SensorID,
+		Tenant:   data.Tenant,
+		Location: data.Location,
+		Value:    data.Value,
+	}
+	if rule.IsRate() {
+		candidate.Change = observed
+	}
+	return c.updateAlert(rule, alertKey(rule, data), candidate, rule.Triggered(observed), rule.Resolved(observed), data, now)
+}
+
+// updateAlert moves the alert stored under key between resolved, pending and firing, given
+// whether a reading triggers or resolves its rule. An alert is pending while its condition
+// has held for less than the rule's duration, measured in reading time, and fires once it
+// has held for the whole duration. The duration only counts while readings keep meeting the
+// condition: after a gap longer than the sensor's stale timeout, e.g. while the consumer was
+// down, it starts over. A firing alert is notified again every renotify interval of its rule.
+// A new alert starts from candidate, an active one takes over its latest values.
+// Reports whether the alert state changed.
+func (c *DataConsumer) updateAlert(rule *AlertRule, key string, candidate *ActiveAlert, triggered, resolved bool, data SensorData, now time.Time) bool {
+	alert, active := c.alertState.Alerts[key]
+	firing := active && alert.Status == AlertFiring
+	if active {
+		alert.Value = candidate.Value
+		alert.Change = candidate.Change
+	}
+
+	switch {
+	case !active && triggered:
+		alert = candidate
+		alert.Status = AlertPending
+		alert.PendingSince = data.Timestamp
+		alert.LastSeen = data.Timestamp
+		c.alertState.Alerts[key] = alert
+		if rule.For > 0 {
+			log.Printf("Alert rule %s pending for %s: %.2f%s", rule.Name, alertSubject(alert, data), data.Value, data.Unit)
+			return true
+		}
+		c.fireAlert(rule, alert, data, now)
+		return true
+
+	case active && !firing && !triggered:
+		log.Printf("Alert rule %s no longer pending for %s: %.2f%s", rule.Name, alertSubject(alert, data), data.Value, data.Unit)
+		delete(c.alertState.Alerts, key)
+		return true
+
+	case active && !firing:
+		if timeout := c.staleTimeout(data); alert.LastSeen.IsZero() || (timeout > 0 && data.Timestamp.Sub(alert.LastSeen) > timeout) {
+			log.Printf("Alert rule %s pending again for %s: no reading met the condition since %s",
+				rule.Name, alertSubject(alert, data), alert.LastSeen.Format(time.RFC3339))
+			alert.PendingSince = data.Timestamp
+			alert.LastSeen = data.Timestamp
+			return true
+		}
+		if data.Timestamp.After(alert.LastSeen) {
+			alert.LastSeen = data.Timestamp
+		}
+		if data.Timestamp.Sub(alert.PendingSince) >= rule.For {
+			c.fireAlert(rule, alert, data, now)
+			return true
+		}
+
+	case firing && resolved:
+		log.Printf("Alert rule %s resolved for %s: %.2f%s", rule.Name, alertSubject(alert, data), data.Value, data.Unit)
+		delete(c.alertState.Alerts, key)
+		c.sendAlert(rule, AlertResolved, alert, data)
+		return true
+
+	case firing:
+		if c.renotifyInterval(rule) > 0 && now.Sub(alert.LastNotified) >= c.renotifyInterval(rule) {
+			if err := c.sendAlert(rule, AlertFiring, alert, data); err == nil {
+				alert.LastNotified = now
+			}
+			return true
+		}
+	}
+	return false
+}
 
-	return true
+// alertSubject names what an alert is about in log lines: its sensor, or for composite rules
+// its location and the sensor whose reading was evaluated
+func alertSubject(alert *ActiveAlert, data SensorData) string {
+	subject := "its inputs"
+	switch {
+	case alert.SensorID != "":
+		return "sensor " + alert.SensorID
+	case alert.Location != "":
+		subject = "location " + alert.Location
+	}
+	if data.SensorID == "" {
+		return subject
+	}
+	return fmt.Sprintf("%s (%s)", subject, data.SensorID)
 }
 
-// loadAlertState loads the alert state from the file
-func (c *DataConsumer) loadAlertState() (AlertState, error) {
-	var state AlertState
+// fireAlert turns a pending alert into a firing one and notifies it
+func (c *DataConsumer) fireAlert(rule *AlertRule, alert *ActiveAlert, data SensorData, now time.Time) {
+	log.Printf("Alert rule %s triggered by sensor %s at %s: %.2f%s", rule.Name, data.SensorID, data.Location, data.Value, data.Unit)
+	alert.Status = AlertFiring
+	alert.FiredAt = now
+	if err := c.sendAlert(rule, AlertFiring, alert, data); err == nil {
+		alert.LastNotified = now
+	}
+}
 
-	// Check if file exists
-	if _, err := os.Stat(c.alertStateFile); os.IsNotExist(err) {
-		// Return empty state if file doesn't exist
-		return state, nil
+// renotifyInterval returns how often a firing alert of a rule is repeated
+func (c *DataConsumer) renotifyInterval(rule *AlertRule) time.Duration {
+	if rule.RenotifyInterval != nil {
+		return *rule.RenotifyInterval
 	}
+	return c.alertRenotifyInterval
+}
+
+// loadAlertState loads the pending and firing alerts from the state file, dropping
+// alerts of rules that no longer exist. A file that can't be parsed is logged and
+// ignored, so that a damaged file doesn't keep the consumer from starting.
+func (c *DataConsumer) loadAlertState() error {
+	c.alertState = AlertState{Alerts: make(map[string]*ActiveAlert)}
 
-	// Read file
+	// Check if file exists
 	data, err := os.ReadFile(c.alertStateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
-		return state, err
+		return err
 	}
 
 	// Parse JSON
+	var state AlertState
 	if len(data) > 0 {
-		err = json.Unmarshal(data, &state)
-		if err != nil {
-			return state, err
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Printf("Ignoring unreadable alert state %s, starting without pending or firing alerts: %v", c.alertStateFile, err)
+			return nil
 		}
 	}
 
-	return state, nil
+	rules := make(map[string]bool, len(c.alertRules))
+	for _, rule := range c.alertRules {
+		rules[rule.Name] = true
+	}
+	for key, alert := range state.Alerts {
+		if !rules[alert.Rule] {
+			continue
+		}
+		// Alerts saved before pending alerts existed were all firing
+		if alert.Status == "" {
+			alert.Status = AlertFiring
+		}
+		c.alertState.Alerts[key] = alert
+	}
+	log.Printf("Loaded %d pending or firing alerts from %s", len(c.alertState.Alerts), c.alertStateFile)
+	return nil
 }
 
-// saveAlertState saves the current alert state to the file
-func (c *DataConsumer) saveAlertState(state AlertState) error {
+// saveAlertState saves the current alert state to the file. The state is written to a
+// temporary file that replaces the old one, so a crash never leaves a partial file behind.
+func (c *DataConsumer) saveAlertState() error {
+	// Create directory for alert state file if it doesn't exist
+	dir := filepath.Dir(c.alertStateFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
 	// Marshal to JSON
-	data, err := json.Marshal(state)
+	data, err := json.Marshal(c.alertState)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory and move it into place
+	tmp, err := os.CreateTemp(dir, filepath.Base(c.alertStateFile)+".*.tmp")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name())
 
-	// Write to file
-	return os.WriteFile(c.alertStateFile, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.alertStateFile)
 }
 
-// sendTemperatureAlert sends a temperature alert via NATS to the email service
-func (c *DataConsumer) sendTemperatureAlert(data SensorData) error {
-	// Create alert message
-	alertMsg := map[string]string{
-		"subject": fmt.Sprintf("High Temperature Alert: %.2f°C", data.Value),
-		"message": fmt.Sprintf(
-			"Warning: High temperature detected!\n\n"+
-				"Sensor ID: %s\n"+
-				"Location: %s\n"+
-				"Temperature: %.2f°C\n"+
-				"Time: %s\n\n"+
-				"Please check the system as soon as possible.",
-			data.SensorID,
-			data.Location,
-			data.Value,
-			data.Timestamp.Format(time.RFC1123),
-		),
-	}
-
-	// Convert to JSON
-	jsonData, err := json.Marshal(alertMsg)
+// sendEmail asks the email service to send a message
+func (c *DataConsumer) sendEmail(subject, message string) error {
+	jsonData, err := json.Marshal(map[string]string{
+		"subject": subject,
+		"message": message,
+	})
 	if err != nil {
-		log.Printf("Failed to create alert message: %v", err)
 		return err
 	}
+	return c.natsConn.Publish("emails", jsonData)
+}
+
+// sendAlert sends a firing or resolved notification of a rule via NATS to the email service
+func (c *DataConsumer) sendAlert(rule *AlertRule, status string, alert *ActiveAlert, data SensorData) error {
+	// Describe the sensor, or for composite rules every input in the alert's group
+	headline := fmt.Sprintf("%s %.2f%s", data.SensorID, data.Value, data.Unit)
+	condition := data.SensorType + " " + rule.Condition()
+	value := fmt.Sprintf("%.2f%s", data.Value, data.Unit)
+	if rule.IsRate() {
+		value += fmt.Sprintf(" (change %+.2f%s)", alert.Change, data.Unit)
+	}
+	details := fmt.Sprintf("Sensor ID: %s\nLocation: %s\nValue: %s\n", data.SensorID, data.Location, value)
+	if rule.IsComposite() {
+		inputs := c.compositeInputs(rule, data)
+		sort.Slice(inputs, func(i, j int) bool { return inputs[i].SensorID < inputs[j].SensorID })
+
+		ids := make([]string, len(inputs))
+		details = ""
+		if alert.Location != "" {
+			details = fmt.Sprintf("Location: %s\n", alert.Location)
+		}
+		details += "Inputs:\n"
+		for i, input := range inputs {
+			ids[i] = input.SensorID
+			details += fmt.Sprintf("  %s (%s, %s): %.2f%s at %s\n",
+				input.SensorID, input.SensorType, input.Location, input.Value, input.Unit, input.Timestamp.Format(time.RFC1123))
+		}
+		headline = alert.Location
+		if headline == "" {
+			headline = strings.Join(ids, ", ")
+		}
+		condition = rule.Condition()
+	}
+
+	// Create alert message
+	subject := fmt.Sprintf("[%s] %s: %s", strings.ToUpper(rule.Severity), rule.Name, headline)
+	intro := fmt.Sprintf("Alert rule %s was triggered (severity: %s).", rule.Name, rule.Severity)
+	outro := "Please check the system as soon as possible."
+	if status == AlertResolved {
+		subject = fmt.Sprintf("[RESOLVED] %s: %s", rule.Name, headline)
+		intro = fmt.Sprintf("Alert rule %s has cleared (severity: %s).", rule.Name, rule.Severity)
+		outro = "No further action is needed."
+	}
+	if status == AlertStale {
+		subject = fmt.Sprintf("[STALE] %s: %s", rule.Name, headline)
+		intro = fmt.Sprintf("Alert rule %s was resolved because some of its inputs have no reading within their maxAge (severity: %s).", rule.Name, rule.Severity)
+		outro = "Please check whether the sensors are still reporting."
+	}
+	message := fmt.Sprintf(
+		"%s\n\n"+
+			"Condition: %s\n"+
+			"%s"+
+			"Firing since: %s\n"+
+			"Time: %s\n\n"+
+			"%s",
+		intro,
+		condition,
+		details,
+		alert.FiredAt.Format(time.RFC1123),
+		data.Timestamp.Format(time.RFC1123),
+		outro,
+	)
 
 	// Send to email service via NATS
-	err = c.natsConn.Publish("emails", jsonData)
-	if err != nil {
+	if err := c.sendEmail(subject, message); err != nil {
 		log.Printf("Failed to send alert via NATS: %v", err)
+		alertFailures.WithLabelValues(metricSensorType(data.SensorType)).Inc()
 		return err
 	}
-
-	log.Printf("Temperature alert sent for sensor %s", data.SensorID)
-
-	// Update alert state
-	now := time.Now()
-	state := AlertState{LastAlertTime: now}
-	if err := c.saveAlertState(state); err != nil {
-		log.Printf("Failed to save alert state: %v", err)
+	if status == AlertResolved || status == AlertStale {
+		alertsResolved.WithLabelValues(metricSensorType(data.SensorType)).Inc()
+	} else {
+		alertsSent.WithLabelValues(metricSensorType(data.SensorType)).Inc()
 	}
 
+	log.Printf("Alert %s (%s) sent for sensor %s", rule.Name, status, data.SensorID)
 	return nil
 }
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUpdateAlert(t *testing.T) {
+	now := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	hour := time.Hour
+	const key = "high temperature|temp_001"
+
+	tests := []struct {
+		name      string
+		rule      *AlertRule
+		active    *ActiveAlert
+		triggered bool
+		resolved  bool
+		// at is the timestamp of the reading, relative to now
+		at          time.Duration
+		wantChanged bool
+		// wantStatus is the status of the stored alert, empty when there is none
+		wantStatus string
+		// wantRestart expects the pending duration to start over at the reading
+		wantRestart bool
+	}{
+		{
+			name:        "not triggered",
+			rule:        &AlertRule{},
+			resolved:    true,
+			wantChanged: false,
+		},
+		{
+			name:        "triggered fires at once",
+			rule:        &AlertRule{},
+			triggered:   true,
+			wantChanged: true,
+			wantStatus:  AlertFiring,
+		},
+		{
+			name:        "triggered with a duration is pending",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			triggered:   true,
+			wantChanged: true,
+			wantStatus:  AlertPending,
+		},
+		{
+			name:        "pending for less than the duration",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			active:      &ActiveAlert{Status: AlertPending, PendingSince: now.Add(-5 * time.Minute), LastSeen: now.Add(-time.Minute)},
+			triggered:   true,
+			wantChanged: false,
+			wantStatus:  AlertPending,
+		},
+		{
+			name:        "pending for the whole duration",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			active:      &ActiveAlert{Status: AlertPending, PendingSince: now.Add(-5 * time.Minute), LastSeen: now.Add(4 * time.Minute)},
+			triggered:   true,
+			at:          5 * time.Minute,
+			wantChanged: true,
+			wantStatus:  AlertFiring,
+		},
+		{
+			name:        "pending after a gap in the readings",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			active:      &ActiveAlert{Status: AlertPending, PendingSince: now.Add(-20 * time.Minute), LastSeen: now.Add(-15 * time.Minute)},
+			triggered:   true,
+			wantChanged: true,
+			wantStatus:  AlertPending,
+			wantRestart: true,
+		},
+		{
+			name:        "pending restored without a last reading",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			active:      &ActiveAlert{Status: AlertPending, PendingSince: now.Add(-20 * time.Minute)},
+			triggered:   true,
+			wantChanged: true,
+			wantStatus:  AlertPending,
+			wantRestart: true,
+		},
+		{
+			name:        "pending no longer triggered",
+			rule:        &AlertRule{For: 10 * time.Minute},
+			active:      &ActiveAlert{Status: AlertPending, PendingSince: now.Add(-5 * time.Minute)},
+			wantChanged: true,
+		},
+		{
+			name:        "firing between the thresholds",
+			rule:        &AlertRule{},
+			active:      &ActiveAlert{Status: AlertFiring, LastNotified: now},
+			wantChanged: false,
+			wantStatus:  AlertFiring,
+		},
+		{
+			name:        "firing resolved",
+			rule:        &AlertRule{},
+			active:      &ActiveAlert{Status: AlertFiring, LastNotified: now},
+			resolved:    true,
+			wantChanged: true,
+		},
+		{
+			name:        "firing within the renotify interval",
+			rule:        &AlertRule{RenotifyInterval: &hour},
+			active:      &ActiveAlert{Status: AlertFiring, LastNotified: now.Add(-30 * time.Minute)},
+			triggered:   true,
+			wantChanged: false,
+			wantStatus:  AlertFiring,
+		},
+		{
+			name:        "firing past the renotify interval",
+			rule:        &AlertRule{RenotifyInterval: &hour},
+			active:      &ActiveAlert{Status: AlertFiring, LastNotified: now.Add(-2 * time.Hour)},
+			triggered:   true,
+			wantChanged: true,
+			wantStatus:  AlertFiring,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a NATS connection every notification fails, which leaves LastNotified alone
+			c := &DataConsumer{
+				alertStateFile: filepath.Join(t.TempDir(), "alert_state.json"),
+				alertState:     AlertState{Alerts: make(map[string]*ActiveAlert)},
+				// Readings may be up to three minutes apart
+				staleDefaultInterval: time.Minute,
+				staleIntervalFactor:  3,
+			}
+			tt.rule.Name = "high temperature"
+			if tt.active != nil {
+				tt.active.Rule = tt.rule.Name
+				tt.active.SensorID = "temp_001"
+				c.alertState.Alerts[key] = tt.active
+			}
+			data := SensorData{SensorType: "temperature", SensorID: "temp_001", Value: 31, Timestamp: now.Add(tt.at)}
+			candidate := &ActiveAlert{Rule: tt.rule.Name, SensorID: data.SensorID, Value: data.Value}
+
+			changed := c.updateAlert(tt.rule, key, candidate, tt.triggered, tt.resolved, data, now)
+			if changed != tt.wantChanged {
+				t.Errorf("got changed %v, want %v", changed, tt.wantChanged)
+			}
+
+			alert, ok := c.alertState.Alerts[key]
+			switch {
+			case tt.wantStatus == "" && ok:
+				t.Fatalf("got a %s alert, want none", alert.Status)
+			case tt.wantStatus == "":
+				return
+			case !ok:
+				t.Fatalf("got no alert, want a %s one", tt.wantStatus)
+			}
+			if alert.Status != tt.wantStatus {
+				t.Errorf("got status %s, want %s", alert.Status, tt.wantStatus)
+			}
+			if alert.Value != data.Value {
+				t.Errorf("got value %v, want the latest %v", alert.Value, data.Value)
+			}
+			if (tt.active == nil || tt.wantRestart) && !alert.PendingSince.Equal(data.Timestamp) {
+				t.Errorf("got pending since %v, want %v", alert.PendingSince, data.Timestamp)
+			}
+			if tt.active != nil && tt.active.Status == AlertPending && alert.Status == AlertFiring && !alert.FiredAt.Equal(now) {
+				t.Errorf("got fired at %v, want %v", alert.FiredAt, now)
+			}
+		})
+	}
+}
+
+func TestEvaluateAlertHysteresis(t *testing.T) {
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	clear := 28.0
+	rule := &AlertRule{Name: "high temperature", SensorType: "temperature", Operator: OperatorAbove, Threshold: 30, ClearThreshold: &clear}
+	c := &DataConsumer{
+		alertStateFile: filepath.Join(t.TempDir(), "alert_state.json"),
+		alertState:     AlertState{Alerts: make(map[string]*ActiveAlert)},
+	}
+
+	// The alert fires above 30 and only resolves at or below 28
+	steps := []struct {
+		value      float64
+		wantStatus string
+	}{
+		{29, ""},
+		{31, AlertFiring},
+		{29, AlertFiring},
+		{30.5, AlertFiring},
+		{28, ""},
+		{29, ""},
+	}
+	for i, step := range steps {
+		data := SensorData{SensorType: "temperature", SensorID: "temp_001", Value: step.value, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		c.evaluateAlert(rule, data, data.Timestamp)
+
+		status := ""
+		if alert, ok := c.alertState.Alerts[alertKey(rule, data)]; ok {
+			status = alert.Status
+		}
+		if status != step.wantStatus {
+			t.Errorf("reading %d (%v): got status %q, want %q", i, step.value, status, step.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DecodedReading is one reading of a message together with the bytes it was decoded from
+type DecodedReading struct {
+	Index   int
+	Payload []byte
+	Data    SensorData
+	Err     error
+}
+
+// DecodeJSONReadings decodes a single JSON reading, a JSON array of readings or
+// newline-delimited JSON readings. Readings that fail to decode carry their own
+// error; the returned error is only set when the message as a whole is unusable.
+func DecodeJSONReadings(payload []byte) ([]DecodedReading, error) {
+	trimmed := bytes.TrimSpace(payload)
+	if len(trimmed) == 0 {
+		return nil, &ValidationError{Reason: ReasonDecode, Message: "message is empty"}
+	}
+
+	var items [][]byte
+	switch {
+	case trimmed[0] == '[':
+		// JSON array of readings
+		var rawItems []json.RawMessage
+		if err := json.Unmarshal(trimmed, &rawItems); err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+		for _, rawItem := range rawItems {
+			items = append(items, rawItem)
+		}
+	case json.Valid(trimmed):
+		// Single reading, possibly spread over several lines
+		items = append(items, trimmed)
+	default:
+		// Newline-delimited readings
+		for _, line := range bytes.Split(trimmed, []byte("\n")) {
+			line = bytes.TrimSpace(line)
+			if len(line) > 0 {
+				items = append(items, line)
+			}
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, &ValidationError{Reason: ReasonDecode, Message: "message contains no readings"}
+	}
+
+	readings := make([]DecodedReading, len(items))
+	for i, item := range items {
+		readings[i] = DecodedReading{Index: i, Payload: item}
+		if err := json.Unmarshal(item, &readings[i].Data); err != nil {
+			readings[i].Err = &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+	}
+	return readings, nil
+}
+
+// prepareReadings normalizes and validates decoded readings against the placeholder values
+// of their subject or topic, splitting them into readings that can be stored and readings
+// that have to be dead-lettered
+func (c *DataConsumer) prepareReadings(subjectValues map[string]string, readings []DecodedReading) ([]SensorData, []DecodedReading) {
+	var valid []SensorData
+	var rejected []DecodedReading
+	now := time.Now()
+	registry := c.sensorRegistry()
+
+	for _, reading := range readings {
+		if reading.Err != nil {
+			decodeFailures.Inc()
+			rejected = append(rejected, reading)
+			continue
+		}
+
+		log.Printf("Received reading: %+v", reading.Data)
+
+		// Check the payload against its subject, then convert alternative units
+		// before checking physical ranges
+		data, err := c.applySubject(reading.Data, subjectValues)
+		readingsReceived.WithLabelValues(metricSensorType(data.SensorType)).Inc()
+
+		// Add what the registry knows about the sensor, including the unit it reports in
+		info, registered := registry.Lookup(data)
+		if err == nil && registered {
+			data = info.Enrich(data)
+		}
+		if err == nil {
+			data, err = NormalizeUnit(data)
+		}
+		if err == nil && registered && info.Calibration != nil {
+			data = info.Calibration.Calibrate(data)
+		}
+		if err == nil {
+			// Reject readings that are incomplete or physically implausible
+			err = ValidateSensorData(data, now, c.maxTimestampSkew, c.maxTimestampAge)
+		}
+		if err == nil && registered {
+			err = info.CheckRange(data)
+		}
+		if err == nil {
+			err = c.checkTenant(data)
+		}
+		if err != nil {
+			log.Printf("Rejected reading %d from sensor %q: %v", reading.Index, reading.Data.SensorID, err)
+			validationFailures.WithLabelValues(metricSensorType(data.SensorType), rejectionReason(err)).Inc()
+			reading.Err = err
+			rejected = append(rejected, reading)
+			continue
+		}
+
+		valid = append(valid, data)
+	}
+
+	return valid, rejected
+}
+
+// storeReadings validates, deduplicates and stores decoded readings, returning the readings
+// that were stored and the ones that were rejected. Only the stored readings are recorded for
+// deduplication; the message itself is recorded once it has been fully handled.
+func (c *DataConsumer) storeReadings(subjectValues map[string]string, readings []DecodedReading) ([]SensorData, []DecodedReading, error) {
+	valid, rejected := c.prepareReadings(subjectValues, readings)
+	stored, err := c.storeValid(valid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stored, rejected, nil
+}
+
+// storeValid drops duplicates from validated readings, tags stuck and outlying readings with
+// their quality and stores the rest, returning the readings that were stored. Every source
+// of readings stores them this way, so that flatlines and outliers are caught for all of them.
+func (c *DataConsumer) storeValid(valid []SensorData) ([]SensorData, error) {
+	valid = c.dropDuplicateReadings(valid)
+	valid, stuck := c.flatlines.Check(valid)
+	if c.outliers != nil {
+		valid = c.outliers.Grade(valid)
+	}
+
+	if len(valid) > 0 {
+		if err := c.StoreBatch(valid); err != nil {
+			return nil, err
+		}
+	}
+	c.recordStored("", valid)
+	c.publishStuck(stuck)
+	return valid, nil
+}
+
+// deadLetterReadings republishes every rejected reading of a message to the dead-letter subject.
+// Readings are already decompressed, so only the content type of the message is carried over.
+func (c *DataConsumer) deadLetterReadings(subject, contentType string, rejected []DecodedReading) error {
+	header := nats.Header{}
+	if contentType != "" {
+		header.Set(HeaderContentType, itemContentType(contentType))
+	}
+
+	for _, reading := range rejected {
+		if err := c.publishDeadLetter(subject, reading.Payload, header, reading.Err, reading.Index); err != nil {
+			return fmt.Errorf("failed to dead-letter reading %d: %w", reading.Index, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TagCalibrationVersion names the calibration applied to a reading
+const TagCalibrationVersion = "calibrationVersion"
+
+// Calibration corrects the values of one sensor, either linearly (value*gain + offset,
+// with a gain of 1 unless set) or along a piecewise-linear curve of raw → corrected points.
+// Values are in the canonical unit of the sensor type.
+type Calibration struct {
+	Version string       `yaml:"version"`
+	Offset  float64      `yaml:"offset"`
+	Gain    *float64     `yaml:"gain"`
+	Points  [][2]float64 `yaml:"points"`
+}
+
+// Validate checks that a calibration is complete and its curve is usable
+func (cal *Calibration) Validate() error {
+	if cal.Version == "" {
+		return fmt.Errorf("calibration has no version")
+	}
+	// A gain of 0 would replace every reading by the offset, which is always a mistake
+	if cal.Gain != nil && *cal.Gain == 0 {
+		return fmt.Errorf("calibration %s has a gain of 0", cal.Version)
+	}
+	if len(cal.Points) == 0 {
+		return nil
+	}
+	if cal.Offset != 0 || cal.Gain != nil {
+		return fmt.Errorf("calibration %s mixes offset/gain with points", cal.Version)
+	}
+	if len(cal.Points) < 2 {
+		return fmt.Errorf("calibration %s needs at least two points", cal.Version)
+	}
+
+	points := append([][2]float64(nil), cal.Points...)
+	sort.Slice(points, func(i, j int) bool { return points[i][0] < points[j][0] })
+	for i := 1; i < len(points); i++ {
+		if points[i][0] == points[i-1][0] {
+			return fmt.Errorf("calibration %s has two points for raw value %v", cal.Version, points[i][0])
+		}
+	}
+	cal.Points = points
+	return nil
+}
+
+// Apply returns the corrected value for a raw value. Curves are extended beyond
+// their first and last points along the outermost segments.
+func (cal *Calibration) Apply(raw float64) float64 {
+	if len(cal.Points) == 0 {
+		gain := 1.0
+		if cal.Gain != nil {
+			gain = *cal.Gain
+		}
+		return raw*gain + cal.Offset
+	}
+
+	// Find the segment containing raw, clamping to the outermost segments
+	i := sort.Search(len(cal.Points), func(i int) bool { return cal.Points[i][0] >= raw })
+	if i == 0 {
+		i = 1
+	}
+	if i == len(cal.Points) {
+		i = len(cal.Points) - 1
+	}
+	lo, hi := cal.Points[i-1], cal.Points[i]
+	return lo[1] + (raw-lo[0])*(hi[1]-lo[1])/(hi[0]-lo[0])
+}
+
+// Calibrate corrects a reading, keeping the uncorrected value as its raw value and
+// tagging it with the calibration version
+func (cal *Calibration) Calibrate(data SensorData) SensorData {
+	raw := data.Value
+	data.RawValue = &raw
+	data.Value = cal.Apply(raw)
+	return data.WithTag(TagCalibrationVersion, cal.Version)
+}
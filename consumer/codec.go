@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Headers describing the encoding of a message
+const (
+	HeaderContentType     = "Content-Type"
+	HeaderContentEncoding = "Content-Encoding"
+)
+
+// Supported values of the Content-Type header
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeCBOR     = "application/cbor"
+	ContentTypeMsgPack  = "application/msgpack"
+)
+
+// Supported values of the Content-Encoding header
+const (
+	ContentEncodingGzip = "gzip"
+	ContentEncodingZstd = "zstd"
+)
+
+// protobufBatchMessage is the proto parameter announcing a SensorReadingBatch
+const protobufBatchMessage = "acme.sensors.v1.SensorReadingBatch"
+
+// maxDecompressedSize caps how large a compressed message may become once decompressed
+const maxDecompressedSize = 16 << 20
+
+// contentTypeAliases maps alternative media type spellings to the supported content types
+var contentTypeAliases = map[string]string{
+	ContentTypeJSON:                   ContentTypeJSON,
+	"text/json":                       ContentTypeJSON,
+	"application/x-ndjson":            ContentTypeJSON,
+	ContentTypeProtobuf:               ContentTypeProtobuf,
+	"application/protobuf":            ContentTypeProtobuf,
+	"application/vnd.google.protobuf": ContentTypeProtobuf,
+	ContentTypeCBOR:                   ContentTypeCBOR,
+	ContentTypeMsgPack:                ContentTypeMsgPack,
+	"application/x-msgpack":           ContentTypeMsgPack,
+	"application/vnd.msgpack":         ContentTypeMsgPack,
+}
+
+// zstdDecoder is shared by all messages; DecodeAll is safe for concurrent use
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+
+// DecodeReadings decompresses a payload according to its Content-Encoding and
+// decodes it according to its Content-Type. An empty Content-Type means JSON.
+func DecodeReadings(payload []byte, contentType, contentEncoding string) ([]DecodedReading, error) {
+	payload, err := decompress(payload, contentEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType == "" {
+		return DecodeJSONReadings(payload)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, &ValidationError{Reason: ReasonUnsupportedFormat, Message: fmt.Sprintf("invalid content type %q: %v", contentType, err)}
+	}
+
+	switch contentTypeAliases[mediaType] {
+	case ContentTypeJSON:
+		return DecodeJSONReadings(payload)
+	case ContentTypeProtobuf:
+		return decodeProtobufReadings(payload, params["proto"] == protobufBatchMessage)
+	case ContentTypeCBOR:
+		return decodeCBORReadings(payload)
+	case ContentTypeMsgPack:
+		return decodeMsgPackReadings(payload)
+	default:
+		return nil, &ValidationError{Reason: ReasonUnsupportedFormat, Message: fmt.Sprintf("unsupported content type %q", mediaType)}
+	}
+}
+
+// itemContentType returns the content type of a single reading taken out of a message
+func itemContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// decompress undoes the Content-Encoding of a payload
+func decompress(payload []byte, contentEncoding string) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return payload, nil
+	case ContentEncodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: fmt.Sprintf("invalid gzip data: %v", err)}
+		}
+		defer reader.Close()
+
+		data, err := io.ReadAll(io.LimitReader(reader, maxDecompressedSize+1))
+		if err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: fmt.Sprintf("invalid gzip data: %v", err)}
+		}
+		if len(data) > maxDecompressedSize {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: "decompressed message is too large"}
+		}
+		return data, nil
+	case ContentEncodingZstd:
+		data, err := zstdDecoder.DecodeAll(payload, nil)
+		if err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: fmt.Sprintf("invalid zstd data: %v", err)}
+		}
+		return data, nil
+	default:
+		return nil, &ValidationError{Reason: ReasonUnsupportedFormat, Message: fmt.Sprintf("unsupported content encoding %q", contentEncoding)}
+	}
+}
+
+// decodeCBORReadings decodes a CBOR map holding one reading or a CBOR array of readings
+func decodeCBORReadings(payload []byte) ([]DecodedReading, error) {
+	var items []cbor.RawMessage
+	if len(payload) > 0 && payload[0]>>5 == 4 {
+		// Major type 4 is an array
+		if err := cbor.Unmarshal(payload, &items); err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+	} else {
+		items = append(items, payload)
+	}
+
+	readings := make([]DecodedReading, len(items))
+	for i, item := range items {
+		readings[i] = DecodedReading{Index: i, Payload: item}
+		if err := cbor.Unmarshal(item, &readings[i].Data); err != nil {
+			readings[i].Err = &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+	}
+	return nonEmpty(readings)
+}
+
+// decodeMsgPackReadings decodes a MessagePack map holding one reading or a MessagePack array of readings
+func decodeMsgPackReadings(payload []byte) ([]DecodedReading, error) {
+	var items []msgpack.RawMessage
+	if len(payload) > 0 && isMsgPackArray(payload[0]) {
+		if err := msgpack.Unmarshal(payload, &items); err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+	} else {
+		items = append(items, payload)
+	}
+
+	readings := make([]DecodedReading, len(items))
+	for i, item := range items {
+		readings[i] = DecodedReading{Index: i, Payload: item}
+		data, err := decodeMsgPackReading(item)
+		if err != nil {
+			readings[i].Err = &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+			continue
+		}
+		readings[i].Data = data
+	}
+	return nonEmpty(readings)
+}
+
+// msgPackReading mirrors SensorData but leaves the timestamp encoding open
+type msgPackReading struct {
+	SensorType string      `msgpack:"sensorType"`
+	SensorID   string      `msgpack:"sensorId"`
+	Location   string      `msgpack:"location"`
+	Value      float64     `msgpack:"value"`
+	Unit       string      `msgpack:"unit"`
+	Timestamp  interface{} `msgpack:"timestamp"`
+	Tenant     string      `msgpack:"tenant"`
+}
+
+// decodeMsgPackReading decodes a single MessagePack reading. Timestamps may be
+// MessagePack timestamps, RFC 3339 strings or Unix seconds.
+func decodeMsgPackReading(payload []byte) (SensorData, error) {
+	// Loose decoding turns every integer into int64 or uint64 and every float into float64
+	decoder := msgpack.NewDecoder(bytes.NewReader(payload))
+	decoder.UseLooseInterfaceDecoding(true)
+
+	var reading msgPackReading
+	if err := decoder.Decode(&reading); err != nil {
+		return SensorData{}, err
+	}
+
+	data := SensorData{
+		SensorType: reading.SensorType,
+		SensorID:   reading.SensorID,
+		Location:   reading.Location,
+		Value:      reading.Value,
+		Unit:       reading.Unit,
+		Tenant:     reading.Tenant,
+	}
+
+	switch timestamp := reading.Timestamp.(type) {
+	case nil:
+	case time.Time:
+		data.Timestamp = timestamp
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return data, fmt.Errorf("invalid timestamp: %w", err)
+		}
+		data.Timestamp = parsed
+	case int64:
+		data.Timestamp = time.Unix(timestamp, 0).UTC()
+	case uint64:
+		data.Timestamp = time.Unix(int64(timestamp), 0).UTC()
+	case float64:
+		seconds, fraction := math.Modf(timestamp)
+		data.Timestamp = time.Unix(int64(seconds), int64(fraction*1e9)).UTC()
+	default:
+		return data, fmt.Errorf("invalid timestamp type %T", timestamp)
+	}
+	return data, nil
+}
+
+// isMsgPackArray reports whether a MessagePack code starts an array
+func isMsgPackArray(code byte) bool {
+	return code >= 0x90 && code <= 0x9f || code == 0xdc || code == 0xdd
+}
+
+// decodeProtobufReadings decodes a SensorReading or a SensorReadingBatch as defined in proto/sensordata.proto
+func decodeProtobufReadings(payload []byte, batch bool) ([]DecodedReading, error) {
+	items := [][]byte{payload}
+	if batch {
+		var err error
+		items, err = protobufBatchItems(payload)
+		if err != nil {
+			return nil, &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+		}
+	}
+
+	readings := make([]DecodedReading, len(items))
+	for i, item := range items {
+		readings[i] = DecodedReading{Index: i, Payload: item}
+		data, err := decodeProtobufReading(item)
+		if err != nil {
+			readings[i].Err = &ValidationError{Reason: ReasonDecode, Message: err.Error()}
+			continue
+		}
+		readings[i].Data = data
+	}
+	return nonEmpty(readings)
+}
+
+// protobufBatchItems returns the encoded readings of a SensorReadingBatch
+func protobufBatchItems(payload []byte) ([][]byte, error) {
+	var items [][]byte
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			item, n := protowire.ConsumeBytes(payload)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			items = append(items, item)
+			payload = payload[n:]
+			continue
+		}
+
+		// Skip unknown fields
+		n = protowire.ConsumeFieldValue(num, typ, payload)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+	}
+	return items, nil
+}
+
+// decodeProtobufReading decodes a single SensorReading
+func decodeProtobufReading(payload []byte) (SensorData, error) {
+	var data SensorData
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return data, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		switch {
+		case num == 4 && typ == protowire.Fixed64Type:
+			value, n := protowire.ConsumeFixed64(payload)
+			if n < 0 {
+				return data, protowire.ParseError(n)
+			}
+			payload = payload[n:]
+			data.Value = math.Float64frombits(value)
+		case typ == protowire.BytesType:
+			value, n := protowire.ConsumeBytes(payload)
+			if n < 0 {
+				return data, protowire.ParseError(n)
+			}
+			payload = payload[n:]
+
+			switch num {
+			case 1:
+				data.SensorType = string(value)
+			case 2:
+				data.SensorID = string(value)
+			case 3:
+				data.Location = string(value)
+			case 5:
+				data.Unit = string(value)
+			case 6:
+				timestamp, err := decodeProtobufTimestamp(value)
+				if err != nil {
+					return data, fmt.Errorf("invalid timestamp: %w", err)
+				}
+				data.Timestamp = timestamp
+			case 7:
+				data.Tenant = string(value)
+			}
+		default:
+			// Skip unknown fields
+			n = protowire.ConsumeFieldValue(num, typ, payload)
+			if n < 0 {
+				return data, protowire.ParseError(n)
+			}
+			payload = payload[n:]
+		}
+	}
+	return data, nil
+}
+
+// decodeProtobufTimestamp decodes a google.protobuf.Timestamp
+func decodeProtobufTimestamp(payload []byte) (time.Time, error) {
+	var seconds, nanos int64
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+
+		if (num == 1 || num == 2) && typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(payload)
+			if n < 0 {
+				return time.Time{}, protowire.ParseError(n)
+			}
+			payload = payload[n:]
+			if num == 1 {
+				seconds = int64(value)
+			} else {
+				nanos = int64(int32(value))
+			}
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, payload)
+		if n < 0 {
+			return time.Time{}, protowire.ParseError(n)
+		}
+		payload = payload[n:]
+	}
+	return time.Unix(seconds, nanos).UTC(), nil
+}
+
+// nonEmpty rejects messages that decoded to zero readings
+func nonEmpty(readings []DecodedReading) ([]DecodedReading, error) {
+	if len(readings) == 0 {
+		return nil, &ValidationError{Reason: ReasonDecode, Message: "message contains no readings"}
+	}
+	return readings, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var testTimestamp = time.Date(2025, 5, 12, 10, 30, 0, 500000000, time.UTC)
+
+// testReading is the reading every encoded test payload describes
+var testReading = SensorData{
+	SensorType: "temperature",
+	SensorID:   "temp_001",
+	Location:   "Server Room",
+	Value:      22.5,
+	Unit:       "°C",
+	Timestamp:  testTimestamp,
+	Tenant:     "building_a",
+}
+
+// protobufReading encodes testReading as a SensorReading, followed by an unknown field
+func protobufReading(t *testing.T) []byte {
+	t.Helper()
+	var timestamp []byte
+	timestamp = protowire.AppendTag(timestamp, 1, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(testTimestamp.Unix()))
+	timestamp = protowire.AppendTag(timestamp, 2, protowire.VarintType)
+	timestamp = protowire.AppendVarint(timestamp, uint64(testTimestamp.Nanosecond()))
+
+	var b []byte
+	for _, field := range []struct {
+		num   protowire.Number
+		value string
+	}{{1, testReading.SensorType}, {2, testReading.SensorID}, {3, testReading.Location}, {5, testReading.Unit}, {7, testReading.Tenant}} {
+		b = protowire.AppendTag(b, field.num, protowire.BytesType)
+		b = protowire.AppendString(b, field.value)
+	}
+	b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, math.Float64bits(testReading.Value))
+	b = protowire.AppendTag(b, 6, protowire.BytesType)
+	b = protowire.AppendBytes(b, timestamp)
+	b = protowire.AppendTag(b, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 42)
+	return b
+}
+
+// protobufBatch encodes a SensorReadingBatch of the given readings
+func protobufBatch(items ...[]byte) []byte {
+	var b []byte
+	for _, item := range items {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, item)
+	}
+	return b
+}
+
+// testReadingMap is testReading as a generic map, with the timestamp given separately
+func testReadingMap(timestamp interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"sensorType": testReading.SensorType,
+		"sensorId":   testReading.SensorID,
+		"location":   testReading.Location,
+		"value":      testReading.Value,
+		"unit":       testReading.Unit,
+		"timestamp":  timestamp,
+		"tenant":     testReading.Tenant,
+	}
+}
+
+func mustMarshal(t *testing.T, marshal func(interface{}) ([]byte, error), v interface{}) []byte {
+	t.Helper()
+	b, err := marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func gzipped(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdCompressed(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	return encoder.EncodeAll(payload, nil)
+}
+
+func TestDecodeReadings(t *testing.T) {
+	jsonReading := []byte(`{"sensorType":"temperature","sensorId":"temp_001","location":"Server Room","value":22.5,"unit":"°C","timestamp":"2025-05-12T10:30:00.5Z","tenant":"building_a"}`)
+	msgPackSeconds := testReading
+	msgPackSeconds.Timestamp = testTimestamp.Truncate(time.Second)
+
+	tests := []struct {
+		name            string
+		payload         []byte
+		contentType     string
+		contentEncoding string
+		want            []SensorData
+		// wantItemErr marks readings that are expected to fail on their own
+		wantItemErr []bool
+		// wantReason is the reason of an error for the whole message
+		wantReason string
+	}{
+		{
+			name:    "json without content type",
+			payload: jsonReading,
+			want:    []SensorData{testReading},
+		},
+		{
+			name:        "json array with a broken item",
+			payload:     []byte(`[` + string(jsonReading) + `, {"value": "hot"}]`),
+			contentType: "application/json; charset=utf-8",
+			want:        []SensorData{testReading, {}},
+			wantItemErr: []bool{false, true},
+		},
+		{
+			name:        "newline-delimited json",
+			payload:     append(append(append([]byte{}, jsonReading...), '\n'), jsonReading...),
+			contentType: "application/x-ndjson",
+			want:        []SensorData{testReading, testReading},
+		},
+		{
+			name:        "empty json",
+			payload:     []byte("  "),
+			contentType: ContentTypeJSON,
+			wantReason:  ReasonDecode,
+		},
+		{
+			name:        "protobuf reading",
+			payload:     protobufReading(t),
+			contentType: ContentTypeProtobuf,
+			want:        []SensorData{testReading},
+		},
+		{
+			name:        "protobuf batch",
+			payload:     protobufBatch(protobufReading(t), protobufReading(t)),
+			contentType: ContentTypeProtobuf + "; proto=" + protobufBatchMessage,
+			want:        []SensorData{testReading, testReading},
+		},
+		{
+			name:        "protobuf batch with a truncated item",
+			payload:     protobufBatch(protobufReading(t), protobufReading(t)[:5]),
+			contentType: ContentTypeProtobuf + "; proto=" + protobufBatchMessage,
+			want:        []SensorData{testReading, {}},
+			wantItemErr: []bool{false, true},
+		},
+		{
+			name:        "protobuf batch that is cut off",
+			payload:     protobufBatch(protobufReading(t))[:10],
+			contentType: ContentTypeProtobuf + "; proto=" + protobufBatchMessage,
+			wantReason:  ReasonDecode,
+		},
+		{
+			name:        "empty protobuf batch",
+			payload:     nil,
+			contentType: ContentTypeProtobuf + "; proto=" + protobufBatchMessage,
+			wantReason:  ReasonDecode,
+		},
+		{
+			name:        "cbor reading",
+			payload:     mustMarshal(t, cbor.Marshal, testReadingMap(testTimestamp.Format(time.RFC3339Nano))),
+			contentType: ContentTypeCBOR,
+			want:        []SensorData{testReading},
+		},
+		{
+			name: "cbor array",
+			payload: mustMarshal(t, cbor.Marshal, []interface{}{
+				testReadingMap(testTimestamp.Format(time.RFC3339Nano)),
+				testReadingMap(testTimestamp.Format(time.RFC3339Nano)),
+			}),
+			contentType: ContentTypeCBOR,
+			want:        []SensorData{testReading, testReading},
+		},
+		{
+			name:        "msgpack reading with a native timestamp",
+			payload:     mustMarshal(t, msgpack.Marshal, testReadingMap(testTimestamp)),
+			contentType: ContentTypeMsgPack,
+			want:        []SensorData{testReading},
+		},
+		{
+			name:        "msgpack reading with unix seconds",
+			payload:     mustMarshal(t, msgpack.Marshal, testReadingMap(testTimestamp.Unix())),
+			contentType: "application/x-msgpack",
+			want:        []SensorData{msgPackSeconds},
+		},
+		{
+			name: "msgpack array with a bad timestamp",
+			payload: mustMarshal(t, msgpack.Marshal, []interface{}{
+				testReadingMap(testTimestamp.Format(time.RFC3339Nano)),
+				testReadingMap("yesterday"),
+			}),
+			contentType: ContentTypeMsgPack,
+			want:        []SensorData{testReading, {}},
+			wantItemErr: []bool{false, true},
+		},
+		{
+			name:            "gzip json",
+			payload:         gzipped(t, jsonReading),
+			contentEncoding: "GZIP",
+			want:            []SensorData{testReading},
+		},
+		{
+			name:            "zstd protobuf",
+			payload:         zstdCompressed(t, protobufReading(t)),
+			contentType:     ContentTypeProtobuf,
+			contentEncoding: ContentEncodingZstd,
+			want:            []SensorData{testReading},
+		},
+		{
+			name:            "invalid gzip",
+			payload:         jsonReading,
+			contentEncoding: ContentEncodingGzip,
+			wantReason:      ReasonDecode,
+		},
+		{
+			name:            "unknown encoding",
+			payload:         jsonReading,
+			contentEncoding: "br",
+			wantReason:      ReasonUnsupportedFormat,
+		},
+		{
+			name:        "unknown content type",
+			payload:     jsonReading,
+			contentType: "text/csv",
+			wantReason:  ReasonUnsupportedFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readings, err := DecodeReadings(tt.payload, tt.contentType, tt.contentEncoding)
+			if tt.wantReason != "" {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) || validationErr.Reason != tt.wantReason {
+					t.Fatalf("got error %v, want reason %s", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(readings) != len(tt.want) {
+				t.Fatalf("got %d readings, want %d", len(readings), len(tt.want))
+			}
+			for i, reading := range readings {
+				if reading.Index != i {
+					t.Errorf("reading %d has index %d", i, reading.Index)
+				}
+				wantErr := tt.wantItemErr != nil && tt.wantItemErr[i]
+				if (reading.Err != nil) != wantErr {
+					t.Errorf("reading %d: got error %v, want error %v", i, reading.Err, wantErr)
+				}
+				if wantErr {
+					continue
+				}
+				if !reading.Data.Timestamp.Equal(tt.want[i].Timestamp) {
+					t.Errorf("reading %d: got timestamp %v, want %v", i, reading.Data.Timestamp, tt.want[i].Timestamp)
+				}
+				got, want := reading.Data, tt.want[i]
+				got.Timestamp, want.Timestamp = time.Time{}, time.Time{}
+				if got.SensorType != want.SensorType || got.SensorID != want.SensorID || got.Location != want.Location ||
+					got.Value != want.Value || got.Unit != want.Unit || got.Tenant != want.Tenant {
+					t.Errorf("reading %d: got %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
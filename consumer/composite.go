@@ -0,0 +1,368 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JoinLocation evaluates a composite rule separately for every location, on the sensors there
+const JoinLocation = "location"
+
+// compositeExpiryInterval is how often composite alerts are checked for stale inputs
+const compositeExpiryInterval = 30 * time.Second
+
+// LatestReadings keeps the latest reading of every sensor that composite rules read
+type LatestReadings struct {
+	mu      sync.Mutex
+	sensors map[string]SensorData
+}
+
+// NewLatestReadings creates an empty store of latest readings
+func NewLatestReadings() *LatestReadings {
+	return &LatestReadings{sensors: make(map[string]SensorData)}
+}
+
+// Add records a reading as the latest of its sensor. Reports false, keeping the stored
+// reading, when the reading is not newer than it.
+func (l *LatestReadings) Add(data SensorData) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := sensorKey(data)
+	if latest, ok := l.sensors[key]; ok && !data.Timestamp.After(latest.Timestamp) {
+		return false
+	}
+	l.sensors[key] = data
+	return true
+}
+
+// Find returns the latest readings that match
+func (l *LatestReadings) Find(match func(SensorData) bool) []SensorData {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var found []SensorData
+	for _, data := range l.sensors {
+		if match(data) {
+			found = append(found, data)
+		}
+	}
+	return found
+}
+
+// truth is the outcome of a composite condition, which is unknown while inputs are missing or stale
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthUnknown
+	truthTrue
+)
+
+// IsComposite reports whether the rule combines the conditions of several sensors
+func (r *AlertRule) IsComposite() bool {
+	return len(r.All) > 0 || len(r.Any) > 0 || r.Not != nil
+}
+
+// children returns the conditions a composite group combines
+func (r *AlertRule) children() []*AlertRule {
+	switch {
+	case len(r.All) > 0:
+		return r.All
+	case len(r.Any) > 0:
+		return r.Any
+	case r.Not != nil:
+		return []*AlertRule{r.Not}
+	}
+	return nil
+}
+
+// inputs returns the single-sensor conditions of a composite rule
+func (r *AlertRule) inputs() []*AlertRule {
+	if !r.IsComposite() {
+		return []*AlertRule{r}
+	}
+	var inputs []*AlertRule
+	for _, child := range r.children() {
+		inputs = append(inputs, child.inputs()...)
+	}
+	return inputs
+}
+
+// validateComposite checks a composite rule and its inputs
+func (r *AlertRule) validateComposite() error {
+	if r.SensorType != "" || r.Operator != "" {
+		return fmt.Errorf("rule %s combines other conditions and can't have a sensor type or operator of its own", r.Name)
+	}
+	switch r.JoinOn {
+	case "", JoinLocation:
+	default:
+		return fmt.Errorf("rule %s has unknown joinOn %q", r.Name, r.JoinOn)
+	}
+	inputs := 0
+	return r.validateGroup(r.Name, r.MaxAge, &inputs)
+}
+
+// validateGroup checks a group of a composite rule, naming its unnamed inputs after the rule.
+// Every input needs a maxAge, set on it or on a group around it.
+func (r *AlertRule) validateGroup(rule string, maxAge time.Duration, inputs *int) error {
+	if r.MaxAge < 0 {
+		return fmt.Errorf("rule %s has a negative maxAge", rule)
+	}
+	if r.MaxAge > 0 {
+		maxAge = r.MaxAge
+	}
+
+	groups := 0
+	for _, set := range []bool{len(r.All) > 0, len(r.Any) > 0, r.Not != nil} {
+		if set {
+			groups++
+		}
+	}
+	if groups != 1 {
+		return fmt.Errorf("rule %s needs exactly one of all, any and not in each group", rule)
+	}
+
+	for _, child := range r.children() {
+		if child.For != 0 || child.JoinOn != "" || child.Severity != "" || child.RenotifyInterval != nil {
+			return fmt.Errorf("rule %s sets for, joinOn, severity or renotifyInterval on a nested condition", rule)
+		}
+
+		if child.IsComposite() {
+			if child.SensorType != "" || child.Operator != "" {
+				return fmt.Errorf("rule %s has a group with a sensor type or operator of its own", rule)
+			}
+			if err := child.validateGroup(rule, maxAge, inputs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		*inputs++
+		if child.Name == "" {
+			child.Name = fmt.Sprintf("%s input %d", rule, *inputs)
+		}
+		if child.IsRate() {
+			return fmt.Errorf("rule %s can't combine the rate condition of %s", rule, child.Name)
+		}
+		if child.MaxAge < 0 {
+			return fmt.Errorf("rule %s has a negative maxAge for %s", rule, child.Name)
+		}
+		if child.MaxAge == 0 && maxAge == 0 {
+			return fmt.Errorf("rule %s has no maxAge for %s", rule, child.Name)
+		}
+		if err := child.validateCondition(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads reports whether a reading is an input of the composite rule
+func (r *AlertRule) Reads(data SensorData) bool {
+	for _, input := range r.inputs() {
+		if input.Selects(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameGroup reports whether two readings are evaluated together by the composite rule:
+// readings of the same tenant, and of the same location when the rule joins on location
+func (r *AlertRule) sameGroup(data, other SensorData) bool {
+	if data.Tenant != other.Tenant {
+		return false
+	}
+	return r.JoinOn != JoinLocation || data.Location == other.Location
+}
+
+// groupKey identifies the group of readings a composite rule evaluates a reading with
+func (r *AlertRule) groupKey(data SensorData) string {
+	if r.JoinOn == JoinLocation {
+		return data.Tenant + "|" + data.Location
+	}
+	return data.Tenant
+}
+
+// evaluate computes a composite condition on the latest readings of a group at the time of
+// the reading being evaluated. Readings older than maxAge don't count; an input without
+// fresh readings is unknown. An input holds when any of its fresh readings meets it.
+// With hold set, inputs are tested against their clear thresholds, to find out whether a
+// firing alert still holds; under not the two tests swap.
+func (r *AlertRule) evaluate(group []SensorData, at time.Time, maxAge time.Duration, hold bool) truth {
+	if r.MaxAge > 0 {
+		maxAge = r.MaxAge
+	}
+
+	if !r.IsComposite() {
+		result := truthUnknown
+		for _, data := range group {
+			if !r.Selects(data) || at.Sub(data.Timestamp) > maxAge {
+				continue
+			}
+			if (hold && !r.Resolved(data.Value)) || (!hold && r.Triggered(data.Value)) {
+				return truthTrue
+			}
+			result = truthFalse
+		}
+		return result
+	}
+
+	switch {
+	case len(r.All) > 0:
+		result := truthTrue
+		for _, child := range r.All {
+			switch child.evaluate(group, at, maxAge, hold) {
+			case truthFalse:
+				return truthFalse
+			case truthUnknown:
+				result = truthUnknown
+			}
+		}
+		return result
+	case len(r.Any) > 0:
+		result := truthFalse
+		for _, child := range r.Any {
+			switch child.evaluate(group, at, maxAge, hold) {
+			case truthTrue:
+				return truthTrue
+			case truthUnknown:
+				result = truthUnknown
+			}
+		}
+		return result
+	default:
+		switch r.Not.evaluate(group, at, maxAge, !hold) {
+		case truthTrue:
+			return truthFalse
+		case truthFalse:
+			return truthTrue
+		}
+		return truthUnknown
+	}
+}
+
+// longestMaxAge returns the longest maxAge of any input of a composite condition
+func (r *AlertRule) longestMaxAge(maxAge time.Duration) time.Duration {
+	if r.MaxAge > 0 {
+		maxAge = r.MaxAge
+	}
+	longest := maxAge
+	for _, child := range r.children() {
+		if age := child.longestMaxAge(maxAge); age > longest {
+			longest = age
+		}
+	}
+	return longest
+}
+
+// describeGroup describes a composite condition, e.g. "all of (humidity > 70, temperature > 25)"
+func (r *AlertRule) describeGroup() string {
+	if !r.IsComposite() {
+		return r.SensorType + " " + r.Condition()
+	}
+	parts := make([]string, 0, len(r.children()))
+	for _, child := range r.children() {
+		parts = append(parts, child.describeGroup())
+	}
+	switch {
+	case len(r.All) > 0:
+		return "all of (" + strings.Join(parts, ", ") + ")"
+	case len(r.Any) > 0:
+		return "any of (" + strings.Join(parts, ", ") + ")"
+	default:
+		return "not (" + parts[0] + ")"
+	}
+}
+
+// compositeInputs returns the latest readings of the inputs of a composite rule in the group
+// of a reading
+func (c *DataConsumer) compositeInputs(rule *AlertRule, data SensorData) []SensorData {
+	if c.latest == nil {
+		return nil
+	}
+	return c.latest.Find(func(other SensorData) bool {
+		return rule.sameGroup(data, other) && rule.Reads(other)
+	})
+}
+
+// evaluateComposite re-evaluates a composite rule for the group of a reading that was just
+// added to the latest readings. Reports whether the alert state changed.
+func (c *DataConsumer) evaluateComposite(rule *AlertRule, data SensorData, now time.Time) bool {
+	group := c.compositeInputs(rule, data)
+	candidate := &ActiveAlert{
+		Rule:   rule.Name,
+		Tenant: data.Tenant,
+		Value:  data.Value,
+	}
+	if rule.JoinOn == JoinLocation {
+		candidate.Location = data.Location
+	}
+	key := rule.Name + "|" + rule.groupKey(data)
+	triggered := rule.evaluate(group, data.Timestamp, 0, false) == truthTrue
+	resolved := rule.evaluate(group, data.Timestamp, 0, true) == truthFalse
+	return c.updateAlert(rule, key, candidate, triggered, resolved, data, now)
+}
+
+// runCompositeExpiry periodically resolves composite alerts whose inputs went stale
+func (c *DataConsumer) runCompositeExpiry() {
+	ticker := time.NewTicker(compositeExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			c.expireCompositeAlerts(now)
+		}
+	}
+}
+
+// expireCompositeAlerts re-evaluates the active composite alerts at wall-clock time, as
+// their inputs may have stopped reporting altogether. A firing alert whose inputs no
+// longer tell whether it holds is resolved with a stale notification; a pending one is
+// dropped. Right after a start no input has a reading yet, so a rule is only checked once
+// the consumer has run for longer than the longest maxAge of its inputs.
+func (c *DataConsumer) expireCompositeAlerts(now time.Time) {
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	rules := make(map[string]*AlertRule)
+	for _, rule := range c.alertRules {
+		if rule.IsComposite() && now.Sub(c.alertsSince) > rule.longestMaxAge(0) {
+			rules[rule.Name] = rule
+		}
+	}
+
+	changed := false
+	for key, alert := range c.alertState.Alerts {
+		rule, ok := rules[alert.Rule]
+		if !ok {
+			continue
+		}
+
+		anchor := SensorData{Tenant: alert.Tenant, Location: alert.Location, Timestamp: now}
+		group := c.compositeInputs(rule, anchor)
+		switch {
+		case alert.Status == AlertFiring && rule.evaluate(group, now, 0, true) == truthUnknown:
+			log.Printf("Alert rule %s resolved for %s: inputs are stale", rule.Name, alertSubject(alert, anchor))
+			delete(c.alertState.Alerts, key)
+			c.sendAlert(rule, AlertStale, alert, anchor)
+			changed = true
+		case alert.Status == AlertPending && rule.evaluate(group, now, 0, false) == truthUnknown:
+			log.Printf("Alert rule %s no longer pending for %s: inputs are stale", rule.Name, alertSubject(alert, anchor))
+			delete(c.alertState.Alerts, key)
+			changed = true
+		}
+	}
+	if changed {
+		if err := c.saveAlertState(); err != nil {
+			log.Printf("Failed to save alert state: %v", err)
+		}
+	}
+}
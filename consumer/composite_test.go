@@ -0,0 +1,150 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlertRuleEvaluate(t *testing.T) {
+	now := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	clearHumidity := 60.0
+	humid := &AlertRule{SensorType: "humidity", Operator: OperatorAbove, Threshold: 70, ClearThreshold: &clearHumidity}
+	hot := &AlertRule{SensorType: "temperature", Operator: OperatorAbove, Threshold: 25}
+	reading := func(sensorType string, value float64, age time.Duration) SensorData {
+		return SensorData{SensorType: sensorType, SensorID: sensorType + "_001", Value: value, Timestamp: now.Add(-age)}
+	}
+
+	tests := []struct {
+		name  string
+		rule  *AlertRule
+		group []SensorData
+		hold  bool
+		want  truth
+	}{
+		{
+			name:  "all met",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 30, 0)},
+			want:  truthTrue,
+		},
+		{
+			name:  "all with one input not met",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 20, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "all with a missing input",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 75, 0)},
+			want:  truthUnknown,
+		},
+		{
+			name:  "all with a missing input and one not met",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("temperature", 20, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "all with a stale input",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 30, 10*time.Minute)},
+			want:  truthUnknown,
+		},
+		{
+			name:  "all with a longer maxAge on the input",
+			rule:  &AlertRule{All: []*AlertRule{humid, {SensorType: "temperature", Operator: OperatorAbove, Threshold: 25, MaxAge: time.Hour}}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 30, 10*time.Minute)},
+			want:  truthTrue,
+		},
+		{
+			name:  "any with one input met",
+			rule:  &AlertRule{Any: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 50, 0), reading("temperature", 30, 0)},
+			want:  truthTrue,
+		},
+		{
+			name:  "any with a missing input and one met",
+			rule:  &AlertRule{Any: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("temperature", 30, 0)},
+			want:  truthTrue,
+		},
+		{
+			name:  "any with a missing input and one not met",
+			rule:  &AlertRule{Any: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("temperature", 20, 0)},
+			want:  truthUnknown,
+		},
+		{
+			name:  "any with no input met",
+			rule:  &AlertRule{Any: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 50, 0), reading("temperature", 20, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "input met by one of its sensors",
+			rule:  &AlertRule{All: []*AlertRule{hot}},
+			group: []SensorData{reading("temperature", 20, 0), {SensorType: "temperature", SensorID: "temp_002", Value: 30, Timestamp: now}},
+			want:  truthTrue,
+		},
+		{
+			name:  "not of an input that is met",
+			rule:  &AlertRule{All: []*AlertRule{humid, {Not: hot}}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 30, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "not of an input that is not met",
+			rule:  &AlertRule{All: []*AlertRule{humid, {Not: hot}}},
+			group: []SensorData{reading("humidity", 75, 0), reading("temperature", 20, 0)},
+			want:  truthTrue,
+		},
+		{
+			name:  "not of a missing input",
+			rule:  &AlertRule{Not: hot},
+			group: []SensorData{reading("humidity", 75, 0)},
+			want:  truthUnknown,
+		},
+		{
+			name:  "holding above the clear threshold",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 65, 0), reading("temperature", 30, 0)},
+			hold:  true,
+			want:  truthTrue,
+		},
+		{
+			name:  "not triggered between the thresholds",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 65, 0), reading("temperature", 30, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "no longer holding below the clear threshold",
+			rule:  &AlertRule{All: []*AlertRule{humid, hot}},
+			group: []SensorData{reading("humidity", 55, 0), reading("temperature", 30, 0)},
+			hold:  true,
+			want:  truthFalse,
+		},
+		{
+			name:  "not of an input between the thresholds",
+			rule:  &AlertRule{Not: humid},
+			group: []SensorData{reading("humidity", 65, 0)},
+			want:  truthFalse,
+		},
+		{
+			name:  "not holding for an input between the thresholds",
+			rule:  &AlertRule{Not: humid},
+			group: []SensorData{reading("humidity", 65, 0)},
+			hold:  true,
+			want:  truthTrue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.evaluate(tt.group, now, 5*time.Minute, tt.hold); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
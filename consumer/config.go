@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"strconv"
+	"time"
 )
 
 // Config holds the application configuration
@@ -15,22 +16,168 @@ type Config struct {
 
 	// NATS configuration
 	NatsURL string
-	
+
+	// JetStream configuration
+	JetStreamStream        string
+	JetStreamDurable       string
+	JetStreamMaxAge        time.Duration
+	JetStreamAckWait       time.Duration
+	JetStreamMaxDeliver    int
+	JetStreamNakDelay      time.Duration
+	JetStreamMaxAckPending int
+
+	// Subject configuration
+	SubjectTemplates      string
+	SubjectConflictPolicy string
+
+	// Validation configuration
+	DLQSubjectPrefix string
+	MaxTimestampSkew time.Duration
+	MaxTimestampAge  time.Duration
+
+	// Deduplication configuration
+	DedupMode       string
+	DedupWindow     time.Duration
+	DedupMaxEntries int
+	DedupKVBucket   string
+
+	// Tenant configuration
+	TenantRoutesFile string
+
+	// Sensor registry configuration
+	SensorRegistryFile           string
+	SensorRegistryReloadInterval time.Duration
+
+	// Storage sink configuration
+	Sinks            string
+	FileSinkDir      string
+	FileSinkMaxBytes int
+	FileSinkMaxFiles int
+	SQLiteSinkPath   string
+
+	// Spool configuration
+	SpoolDir             string
+	SpoolSegmentSize     int
+	SpoolMaxBytes        int
+	SpoolReplayInterval  time.Duration
+	SpoolReplayBatchSize int
+
+	// Worker pool configuration
+	WorkerCount      int
+	WorkerQueueSize  int
+	OverloadPolicy   string
+	SensorPriorities string
+
+	// HTTP configuration
+	HTTPAddr          string
+	HTTPAuthTokens    string
+	HTTPIngestMode    string
+	HTTPIngestSubject string
+	HTTPMaxBodyBytes  int
+
+	// MQTT configuration
+	MQTTBrokerURL      string
+	MQTTClientID       string
+	MQTTUsername       string
+	MQTTPassword       string
+	MQTTTopicTemplates string
+	MQTTQoS            int
+	MQTTContentType    string
+
+	// Flatline configuration
+	FlatlineRules string
+
+	// Outlier configuration
+	OutlierWindow           int
+	OutlierMinSamples       int
+	OutlierSuspectThreshold float64
+	OutlierBadThreshold     float64
+
+	// Staleness configuration
+	StatusSubjectPrefix  string
+	StaleDefaultInterval time.Duration
+	StaleIntervalFactor  float64
+	StaleCheckInterval   time.Duration
+	StaleAlertEmails     bool
+
+	// Health configuration
+	ReadyMaxWriteAge time.Duration
+
 	// Alert configuration
-	TempAlertThreshold float64
-	AlertStateFile     string
+	TempAlertThreshold    float64
+	AlertRulesFile        string
+	AlertStateFile        string
+	AlertRenotifyInterval time.Duration
 }
 
 // NewConfig creates a new Config instance with values from environment variables
 func NewConfig() *Config {
 	return &Config{
-		InfluxURL:          getEnv("INFLUXDB_URL", "http://influxdb:8086"),
-		InfluxToken:        getEnv("INFLUXDB_TOKEN", ""),
-		InfluxOrg:          getEnv("INFLUXDB_ORG", "acme_corp"),
-		InfluxBucket:       getEnv("INFLUXDB_BUCKET", "sensor_data"),
-		NatsURL:            getEnv("NATS_URL", "nats://nats:4222"),
-		TempAlertThreshold: getEnvFloat("TEMP_ALERT_THRESHOLD", 30.0),
-		AlertStateFile:     getEnv("ALERT_STATE_FILE", "/app/data/alert_state.json"),
+		InfluxURL:                    getEnv("INFLUXDB_URL", "http://influxdb:8086"),
+		InfluxToken:                  getEnv("INFLUXDB_TOKEN", ""),
+		InfluxOrg:                    getEnv("INFLUXDB_ORG", "acme_corp"),
+		InfluxBucket:                 getEnv("INFLUXDB_BUCKET", "sensor_data"),
+		NatsURL:                      getEnv("NATS_URL", "nats://nats:4222"),
+		JetStreamStream:              getEnv("JETSTREAM_STREAM", "SENSORS"),
+		JetStreamDurable:             getEnv("JETSTREAM_DURABLE", "consumer"),
+		JetStreamMaxAge:              getEnvDuration("JETSTREAM_MAX_AGE", 24*time.Hour),
+		JetStreamAckWait:             getEnvDuration("JETSTREAM_ACK_WAIT", 30*time.Second),
+		JetStreamMaxDeliver:          getEnvInt("JETSTREAM_MAX_DELIVER", 10),
+		JetStreamNakDelay:            getEnvDuration("JETSTREAM_NAK_DELAY", 5*time.Second),
+		SubjectTemplates:             getEnv("SUBJECT_TEMPLATES", "sensors.{type}.{id}"),
+		SubjectConflictPolicy:        getEnv("SUBJECT_CONFLICT_POLICY", ConflictPolicyReject),
+		DLQSubjectPrefix:             getEnv("DLQ_SUBJECT_PREFIX", "sensors.dlq"),
+		MaxTimestampSkew:             getEnvDuration("MAX_TIMESTAMP_SKEW", 5*time.Minute),
+		MaxTimestampAge:              getEnvDuration("MAX_TIMESTAMP_AGE", 7*24*time.Hour),
+		DedupMode:                    getEnv("DEDUP_MODE", DedupModeMemory),
+		DedupWindow:                  getEnvDuration("DEDUP_WINDOW", 10*time.Minute),
+		DedupMaxEntries:              getEnvInt("DEDUP_MAX_ENTRIES", 100000),
+		DedupKVBucket:                getEnv("DEDUP_KV_BUCKET", "sensor_dedup"),
+		TenantRoutesFile:             getEnv("TENANT_ROUTES_FILE", ""),
+		SensorRegistryFile:           getEnv("SENSOR_REGISTRY_FILE", ""),
+		SensorRegistryReloadInterval: getEnvDuration("SENSOR_REGISTRY_RELOAD_INTERVAL", 10*time.Second),
+		Sinks:                        getEnv("SINKS", SinkInflux),
+		FileSinkDir:                  getEnv("FILE_SINK_DIR", "/app/data/readings"),
+		FileSinkMaxBytes:             getEnvInt("FILE_SINK_MAX_BYTES", 64<<20),
+		FileSinkMaxFiles:             getEnvInt("FILE_SINK_MAX_FILES", 10),
+		SQLiteSinkPath:               getEnv("SQLITE_SINK_PATH", "/app/data/readings.db"),
+		SpoolDir:                     getEnv("SPOOL_DIR", "/app/data/spool"),
+		SpoolSegmentSize:             getEnvInt("SPOOL_SEGMENT_SIZE", 8<<20),
+		SpoolMaxBytes:                getEnvInt("SPOOL_MAX_BYTES", 512<<20),
+		SpoolReplayInterval:          getEnvDuration("SPOOL_REPLAY_INTERVAL", 10*time.Second),
+		SpoolReplayBatchSize:         getEnvInt("SPOOL_REPLAY_BATCH_SIZE", 5000),
+		WorkerCount:                  getEnvInt("WORKER_COUNT", 4),
+		WorkerQueueSize:              getEnvInt("WORKER_QUEUE_SIZE", 100),
+		OverloadPolicy:               getEnv("OVERLOAD_POLICY", OverloadBlock),
+		SensorPriorities:             getEnv("SENSOR_PRIORITIES", "temperature=3,humidity=2,electricity=1"),
+		JetStreamMaxAckPending:       getEnvInt("JETSTREAM_MAX_ACK_PENDING", 1000),
+		HTTPAddr:                     getEnv("HTTP_ADDR", ":8080"),
+		HTTPAuthTokens:               getEnv("HTTP_AUTH_TOKENS", ""),
+		HTTPIngestMode:               getEnv("HTTP_INGEST_MODE", IngestModeSync),
+		HTTPIngestSubject:            getEnv("HTTP_INGEST_SUBJECT", "sensors.http"),
+		HTTPMaxBodyBytes:             getEnvInt("HTTP_MAX_BODY_BYTES", 4<<20),
+		MQTTBrokerURL:                getEnv("MQTT_BROKER_URL", ""),
+		MQTTClientID:                 getEnv("MQTT_CLIENT_ID", "nilemeasure-consumer"),
+		MQTTUsername:                 getEnv("MQTT_USERNAME", ""),
+		MQTTPassword:                 getEnv("MQTT_PASSWORD", ""),
+		MQTTTopicTemplates:           getEnv("MQTT_TOPIC_TEMPLATES", "sensors/{type}/{id}"),
+		MQTTQoS:                      getEnvInt("MQTT_QOS", 1),
+		MQTTContentType:              getEnv("MQTT_CONTENT_TYPE", ""),
+		FlatlineRules:                getEnv("FLATLINE_RULES", "temperature=0.01/30m,humidity=0.01/30m,electricity=0/30m"),
+		OutlierWindow:                getEnvInt("OUTLIER_WINDOW", 15),
+		OutlierMinSamples:            getEnvInt("OUTLIER_MIN_SAMPLES", 5),
+		OutlierSuspectThreshold:      getEnvFloat("OUTLIER_SUSPECT_THRESHOLD", 3),
+		OutlierBadThreshold:          getEnvFloat("OUTLIER_BAD_THRESHOLD", 6),
+		StatusSubjectPrefix:          getEnv("STATUS_SUBJECT_PREFIX", "sensors.status"),
+		StaleDefaultInterval:         getEnvDuration("STALE_DEFAULT_INTERVAL", time.Minute),
+		StaleIntervalFactor:          getEnvFloat("STALE_INTERVAL_FACTOR", 3),
+		StaleCheckInterval:           getEnvDuration("STALE_CHECK_INTERVAL", 15*time.Second),
+		StaleAlertEmails:             getEnvBool("STALE_ALERT_EMAILS", false),
+		ReadyMaxWriteAge:             getEnvDuration("READY_MAX_WRITE_AGE", 5*time.Minute),
+		TempAlertThreshold:           getEnvFloat("TEMP_ALERT_THRESHOLD", 30.0),
+		AlertRulesFile:               getEnv("ALERT_RULES_FILE", ""),
+		AlertStateFile:               getEnv("ALERT_STATE_FILE", "/app/data/alert_state.json"),
+		AlertRenotifyInterval:        getEnvDuration("ALERT_RENOTIFY_INTERVAL", 24*time.Hour),
 	}
 }
 
@@ -49,10 +196,52 @@ func getEnvFloat(key string, defaultValue float64) float64 {
 	if value == "" {
 		return defaultValue
 	}
-	
+
 	floatValue, err := strconv.ParseFloat(value, 64)
 	if err != nil {
 		return defaultValue
 	}
 	return floatValue
 }
+
+// getEnvInt gets an environment variable as an int or returns a default value
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return intValue
+}
+
+// getEnvBool gets an environment variable as a bool or returns a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return boolValue
+}
+
+// getEnvDuration gets an environment variable as a time.Duration or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	durationValue, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return durationValue
+}
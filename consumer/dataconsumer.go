@@ -2,19 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/nats-io/nats.go"
 )
 
-// DataConsumer handles consuming data from NATS and storing it in InfluxDB
+// DataConsumer handles consuming data from NATS and storing it in the configured sinks
 type DataConsumer struct { // something
 	// InfluxDB configuration
 	influxURL    string
@@ -25,14 +27,131 @@ type DataConsumer struct { // something
 	// NATS configuration
 	natsURL string
 
+	// JetStream configuration
+	jsStream        string
+	jsDurable       string
+	jsMaxAge        time.Duration
+	jsAckWait       time.Duration
+	jsMaxDeliver    int
+	jsNakDelay      time.Duration
+	jsMaxAckPending int
+
+	// Subject configuration
+	subjectTemplatePatterns string
+	subjectTemplates        []*SubjectTemplate
+	subjectConflictPolicy   string
+
+	// Validation configuration
+	dlqSubjectPrefix string
+	maxTimestampSkew time.Duration
+	maxTimestampAge  time.Duration
+
+	// Deduplication configuration
+	dedupMode       string
+	dedupWindow     time.Duration
+	dedupMaxEntries int
+	dedupKVBucket   string
+
+	// Tenant configuration
+	tenantRoutesFile string
+	tenantRoutes     map[string]TenantRoute
+
+	// Sensor registry configuration
+	registryFile           string
+	registryReloadInterval time.Duration
+
+	// Storage sink configuration
+	sinkNames        string
+	fileSinkDir      string
+	fileSinkMaxBytes int
+	fileSinkMaxFiles int
+	sqliteSinkPath   string
+
+	// Spool configuration
+	spoolDir             string
+	spoolSegmentSize     int64
+	spoolMaxBytes        int64
+	spoolReplayInterval  time.Duration
+	spoolReplayBatchSize int
+
+	// Worker pool configuration
+	workerCount        int
+	workerQueueSize    int
+	overloadPolicy     string
+	sensorPriorityList string
+	sensorPriorities   map[string]int
+
+	// HTTP configuration
+	httpAddr          string
+	httpAuthTokens    []string
+	httpIngestMode    string
+	httpIngestSubject string
+	httpMaxBodyBytes  int
+
+	// MQTT configuration
+	mqttBrokerURL             string
+	mqttClientID              string
+	mqttUsername              string
+	mqttPassword              string
+	mqttTopicTemplatePatterns string
+	mqttTopicTemplates        []*SubjectTemplate
+	mqttQoS                   int
+	mqttContentType           string
+
+	// Flatline configuration
+	flatlineRules string
+
+	// Outlier configuration
+	outlierWindow           int
+	outlierMinSamples       int
+	outlierSuspectThreshold float64
+	outlierBadThreshold     float64
+
+	// Staleness configuration
+	statusSubjectPrefix  string
+	staleDefaultInterval time.Duration
+	staleIntervalFactor  float64
+	staleCheckInterval   time.Duration
+	staleAlertEmails     bool
+
+	// Health configuration
+	readyMaxWriteAge time.Duration
+
 	// Alert configuration
-	tempAlertThreshold float64
-	alertStateFile     string
+	tempAlertThreshold    float64
+	alertRulesFile        string
+	alertRules            []*AlertRule
+	alertStateFile        string
+	alertRenotifyInterval time.Duration
 
 	// Clients
-	influxClient influxdb2.Client
-	writeAPI     api.WriteAPI
+	sink         Sink
+	influxSink   *InfluxSink
 	natsConn     *nats.Conn
+	jetStream    nats.JetStreamContext
+	subscription *nats.Subscription
+	dedupCache   DedupCache
+	staleness    *StalenessTracker
+	flatlines    *FlatlineDetector
+	outliers     *OutlierDetector
+	workerPool   *WorkerPool
+	httpServer   *http.Server
+	mqttClient   mqtt.Client
+	mqttAcks     *mqttAcks
+	alertMu      sync.Mutex
+	alertState   AlertState
+	alertsSince  time.Time
+	rates        *RateHistory
+	latest       *LatestReadings
+
+	// Sensor registry, replaced on reload
+	registry atomic.Pointer[SensorRegistry]
+
+	// Counters
+	duplicates atomic.Uint64
+
+	// Time of the last stored batch in nanoseconds since the Unix epoch
+	lastWrite atomic.Int64
 
 	// For graceful shutdown
 	ctx        context.Context
@@ -44,43 +163,195 @@ func NewDataConsumer(config *Config) *DataConsumer {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	return &DataConsumer{
-		influxURL:          config.InfluxURL,
-		influxToken:        config.InfluxToken,
-		influxOrg:          config.InfluxOrg,
-		influxBucket:       config.InfluxBucket,
-		natsURL:            config.NatsURL,
-		tempAlertThreshold: config.TempAlertThreshold,
-		alertStateFile:     config.AlertStateFile,
-		ctx:                ctx,
-		cancelFunc:         cancel,
+		influxURL:                 config.InfluxURL,
+		influxToken:               config.InfluxToken,
+		influxOrg:                 config.InfluxOrg,
+		influxBucket:              config.InfluxBucket,
+		natsURL:                   config.NatsURL,
+		jsStream:                  config.JetStreamStream,
+		jsDurable:                 config.JetStreamDurable,
+		jsMaxAge:                  config.JetStreamMaxAge,
+		jsAckWait:                 config.JetStreamAckWait,
+		jsMaxDeliver:              config.JetStreamMaxDeliver,
+		jsNakDelay:                config.JetStreamNakDelay,
+		subjectTemplatePatterns:   config.SubjectTemplates,
+		subjectConflictPolicy:     config.SubjectConflictPolicy,
+		dlqSubjectPrefix:          config.DLQSubjectPrefix,
+		maxTimestampSkew:          config.MaxTimestampSkew,
+		maxTimestampAge:           config.MaxTimestampAge,
+		dedupMode:                 config.DedupMode,
+		dedupWindow:               config.DedupWindow,
+		dedupMaxEntries:           config.DedupMaxEntries,
+		dedupKVBucket:             config.DedupKVBucket,
+		tenantRoutesFile:          config.TenantRoutesFile,
+		registryFile:              config.SensorRegistryFile,
+		registryReloadInterval:    config.SensorRegistryReloadInterval,
+		sinkNames:                 config.Sinks,
+		fileSinkDir:               config.FileSinkDir,
+		fileSinkMaxBytes:          config.FileSinkMaxBytes,
+		fileSinkMaxFiles:          config.FileSinkMaxFiles,
+		sqliteSinkPath:            config.SQLiteSinkPath,
+		spoolDir:                  config.SpoolDir,
+		spoolSegmentSize:          int64(config.SpoolSegmentSize),
+		spoolMaxBytes:             int64(config.SpoolMaxBytes),
+		spoolReplayInterval:       config.SpoolReplayInterval,
+		spoolReplayBatchSize:      config.SpoolReplayBatchSize,
+		workerCount:               config.WorkerCount,
+		workerQueueSize:           config.WorkerQueueSize,
+		overloadPolicy:            config.OverloadPolicy,
+		sensorPriorityList:        config.SensorPriorities,
+		jsMaxAckPending:           config.JetStreamMaxAckPending,
+		httpAddr:                  config.HTTPAddr,
+		httpAuthTokens:            parseTokens(config.HTTPAuthTokens),
+		httpIngestMode:            config.HTTPIngestMode,
+		httpIngestSubject:         config.HTTPIngestSubject,
+		httpMaxBodyBytes:          config.HTTPMaxBodyBytes,
+		mqttBrokerURL:             config.MQTTBrokerURL,
+		mqttClientID:              config.MQTTClientID,
+		mqttUsername:              config.MQTTUsername,
+		mqttPassword:              config.MQTTPassword,
+		mqttTopicTemplatePatterns: config.MQTTTopicTemplates,
+		mqttQoS:                   config.MQTTQoS,
+		mqttContentType:           config.MQTTContentType,
+		flatlineRules:             config.FlatlineRules,
+		outlierWindow:             config.OutlierWindow,
+		outlierMinSamples:         config.OutlierMinSamples,
+		outlierSuspectThreshold:   config.OutlierSuspectThreshold,
+		outlierBadThreshold:       config.OutlierBadThreshold,
+		statusSubjectPrefix:       config.StatusSubjectPrefix,
+		staleDefaultInterval:      config.StaleDefaultInterval,
+		staleIntervalFactor:       config.StaleIntervalFactor,
+		staleCheckInterval:        config.StaleCheckInterval,
+		staleAlertEmails:          config.StaleAlertEmails,
+		readyMaxWriteAge:          config.ReadyMaxWriteAge,
+		tempAlertThreshold:        config.TempAlertThreshold,
+		alertRulesFile:            config.AlertRulesFile,
+		alertRenotifyInterval:     config.AlertRenotifyInterval,
+		alertStateFile:            config.AlertStateFile,
+		ctx:                       ctx,
+		cancelFunc:                cancel,
 	}
 }
 
-// Setup initializes connections to InfluxDB and NATS
+// Setup opens the storage sinks and connects to NATS
 func (c *DataConsumer) Setup() error {
-	// Connect to InfluxDB
-	log.Printf("Connecting to InfluxDB at %s", c.influxURL)
-	log.Printf("InfluxDB token: %s", c.influxToken)
+	// Parse the subject templates before the stream is created from them
+	var err error
+	c.subjectTemplates, err = ParseSubjectTemplates(c.subjectTemplatePatterns)
+	if err != nil {
+		return fmt.Errorf("invalid subject templates: %w", err)
+	}
+	if c.subjectConflictPolicy != ConflictPolicyReject && c.subjectConflictPolicy != ConflictPolicyTag {
+		return fmt.Errorf("invalid subject conflict policy %q", c.subjectConflictPolicy)
+	}
 
-	c.influxClient = influxdb2.NewClient(c.influxURL, c.influxToken)
-	c.writeAPI = c.influxClient.WriteAPI(c.influxOrg, c.influxBucket)
+	// Load the tenant routing table
+	if c.tenantRoutesFile != "" {
+		c.tenantRoutes, err = LoadTenantRoutes(c.tenantRoutesFile)
+		if err != nil {
+			return err
+		}
+		log.Printf("Loaded routes for %d tenants from %s", len(c.tenantRoutes), c.tenantRoutesFile)
+	}
 
-	// Setup error handling for InfluxDB write errors
-	errorsCh := c.writeAPI.Errors()
-	go func() {
-		for err := range errorsCh {
-			log.Printf("InfluxDB write error: %s", err.Error())
+	// Load the sensor registry used to enrich readings
+	if c.registryFile != "" {
+		if err := c.loadSensorRegistry(); err != nil {
+			return err
 		}
-	}()
+	}
+
+	// Load the alert rules before readings can trigger them
+	if err := c.loadAlertRules(); err != nil {
+		return err
+	}
+	if err := c.loadAlertState(); err != nil {
+		log.Printf("Failed to load alert state, starting without firing alerts: %v", err)
+	}
+
+	// Resolve composite alerts whose inputs stopped reporting
+	if c.latest != nil {
+		c.alertsSince = time.Now()
+		go c.runCompositeExpiry()
+	}
+
+	// Open the storage sinks
+	c.sink, err = c.newSink()
+	if err != nil {
+		return err
+	}
+	log.Printf("Storing readings in %s", c.sink.Name())
+	c.lastWrite.Store(time.Now().UnixNano())
 
 	// Connect to NATS
 	log.Printf("Connecting to NATS at %s", c.natsURL)
-	var err error
 	c.natsConn, err = nats.Connect(c.natsURL)
 	if err != nil {
 		return fmt.Errorf("failed to connect to NATS: %w", err)
 	}
-	
+
+	// Make sure the sensor stream exists before binding to it
+	c.jetStream, err = c.natsConn.JetStream()
+	if err != nil {
+		return fmt.Errorf("failed to create JetStream context: %w", err)
+	}
+	if err := c.ensureStream(); err != nil {
+		return err
+	}
+
+	// Watch for sensors that keep reporting the same value
+	flatlineRules, err := ParseFlatlineRules(c.flatlineRules)
+	if err != nil {
+		return err
+	}
+	c.flatlines = NewFlatlineDetector(flatlineRules)
+
+	// Grade readings against the recent values of their sensor
+	if c.outlierWindow > 0 {
+		if c.outlierSuspectThreshold <= 0 || c.outlierBadThreshold < c.outlierSuspectThreshold {
+			return fmt.Errorf("invalid outlier thresholds: suspect %v, bad %v", c.outlierSuspectThreshold, c.outlierBadThreshold)
+		}
+		c.outliers = NewOutlierDetector(c.outlierWindow, c.outlierMinSamples, c.outlierSuspectThreshold, c.outlierBadThreshold)
+	}
+
+	// Watch for sensors that stop publishing
+	if c.staleCheckInterval > 0 {
+		c.staleness = NewStalenessTracker()
+		go c.runStalenessCheck()
+	}
+
+	// Start the workers that store messages
+	c.sensorPriorities, err = ParsePriorities(c.sensorPriorityList)
+	if err != nil {
+		return err
+	}
+	c.workerPool, err = NewWorkerPool(c.workerCount, c.workerQueueSize, c.overloadPolicy, c.handleJob, c.rejectJob)
+	if err != nil {
+		return err
+	}
+	c.workerPool.Start()
+	log.Printf("Started %d workers with queues of %d messages (overload policy: %s)", c.workerCount, c.workerQueueSize, c.overloadPolicy)
+
+	// Set up duplicate suppression
+	c.dedupCache, err = c.newDedupCache()
+	if err != nil {
+		return err
+	}
+
+	// Accept readings over HTTP as well
+	if c.httpAddr != "" {
+		if err := c.startHTTPServer(); err != nil {
+			return err
+		}
+	}
+
+	// Bridge readings from field devices that speak MQTT
+	if c.mqttBrokerURL != "" {
+		if err := c.connectMQTT(); err != nil {
+			return err
+		}
+	}
+
 	// Ensure alert state directory exists
 	alertDir := filepath.Dir(c.alertStateFile)
 	if err := os.MkdirAll(alertDir, 0755); err != nil {
@@ -91,7 +362,7 @@ func (c *DataConsumer) Setup() error {
 	go func() {
 		// Wait a bit for all services to start up
 		time.Sleep(5 * time.Second)
-		
+
 		testData := SensorData{
 			SensorType: "temperature",
 			SensorID:   "test_sensor",
@@ -99,7 +370,7 @@ func (c *DataConsumer) Setup() error {
 			Value:      35.0, // Above the threshold to trigger an alert
 			Timestamp:  time.Now(),
 		}
-		
+
 		log.Println("Sending test temperature alert email...")
 		if err := c.sendTestAlert(testData); err != nil {
 			log.Printf("Failed to send test alert: %v", err)
@@ -112,53 +383,194 @@ func (c *DataConsumer) Setup() error {
 	return nil
 }
 
-// StoreData stores sensor data in InfluxDB
-func (c *DataConsumer) StoreData(data SensorData) {
-	
-	p := influxdb2.NewPointWithMeasurement(data.SensorType).
-		AddTag("sensorId", data.SensorID).
-		AddTag("location", data.Location).
-		AddField("value", data.Value).
-		SetTime(data.Timestamp)
-
-	// Write to InfluxDB
-	c.writeAPI.WritePoint(p)
-	log.Printf("Stored data for %s sensor %s", data.SensorType, data.SensorID)
+// StoreData stores sensor data and returns once the write has completed
+func (c *DataConsumer) StoreData(data SensorData) error {
+	return c.StoreBatch([]SensorData{data})
+}
+
+// StoreBatch stores several readings in every configured sink
+func (c *DataConsumer) StoreBatch(batch []SensorData) error {
+	if err := c.sink.Write(c.ctx, batch); err != nil {
+		return err
+	}
+	for _, data := range batch {
+		log.Printf("Stored data for %s sensor %s", data.SensorType, data.SensorID)
+		readingsStored.WithLabelValues(metricSensorType(data.SensorType)).Inc()
+	}
+	c.lastWrite.Store(time.Now().UnixNano())
+	c.recordSeen(batch)
+	return nil
 }
 
 // MessageHandler handles incoming NATS messages
 func (c *DataConsumer) MessageHandler(msg *nats.Msg) {
-	// Decode and parse the message
-	var data SensorData
-	err := json.Unmarshal(msg.Data, &data)
+	// Dead-lettered messages and status events share the sensor stream but are not readings
+	if c.isDeadLetterSubject(msg.Subject) || c.isStatusSubject(msg.Subject) {
+		c.ackMessage(msg)
+		return
+	}
+	messagesReceived.WithLabelValues(SourceNATS).Inc()
+
+	// Drop redelivered or republished messages that were already stored
+	msgKey := messageDedupKey(msg)
+	if c.isDuplicate(msgKey) {
+		c.recordDuplicate(fmt.Sprintf("message %s on %s", msg.Header.Get(HeaderMsgID), msg.Subject))
+		c.ackMessage(msg)
+		return
+	}
+
+	// Decode the message into one or more readings using the encoding announced in its headers
+	contentType := msg.Header.Get(HeaderContentType)
+	readings, err := DecodeReadings(msg.Data, contentType, msg.Header.Get(HeaderContentEncoding))
 	if err != nil {
 		log.Printf("Failed to decode message: %v", err)
+		decodeFailures.Inc()
+		c.rejectMessage(msg, err)
 		return
 	}
 
-	log.Printf("Received message: %+v", data)
+	// Hand the message to the worker owning its sensor
+	j := &job{msg: msg, readings: readings, msgKey: msgKey}
+	key, sensorType := c.routeReadings(c.matchSubject(msg.Subject), msg.Subject, readings)
+	j.priority = c.sensorPriorities[sensorType]
+	c.workerPool.Submit(key, j)
+}
 
-	// Store the data in InfluxDB
-	c.StoreData(data)
-	
-	// Check for temperature alerts
-	if data.SensorType == "temperature" && data.Value > c.tempAlertThreshold {
-		log.Printf("High temperature detected: %.2f°C at %s", data.Value, data.Location)
-		if c.shouldSendAlert() {
-			c.sendTemperatureAlert(data)
+// routeReadings returns the sensor a message is ordered by and its sensor type. A batch
+// is ordered by its first reading; the placeholder values of the subject or topic take
+// precedence over the payload.
+func (c *DataConsumer) routeReadings(values map[string]string, subject string, readings []DecodedReading) (string, string) {
+	key, sensorType := readings[0].Data.SensorID, readings[0].Data.SensorType
+	if values != nil {
+		if id := values[PlaceholderID]; id != "" {
+			key = id
 		}
+		if t := values[PlaceholderType]; t != "" {
+			sensorType = t
+		}
+	}
+	if key == "" {
+		key = subject
 	}
+	return key, sensorType
 }
 
-// SubscribeToSensors subscribes to all sensor topics
+// handleJob processes a queued NATS or MQTT message on a worker
+func (c *DataConsumer) handleJob(j *job) {
+	if j.mqttMsg != nil {
+		c.processMQTTMessage(j)
+		return
+	}
+	c.processMessage(j)
+}
+
+// processMessage validates, stores and acknowledges a decoded message on a worker
+func (c *DataConsumer) processMessage(j *job) {
+	msg := j.msg
+	contentType := msg.Header.Get(HeaderContentType)
+
+	applyTenantHeader(j.readings, msg.Header.Get(HeaderTenantID))
+
+	// Store the batch, asking for redelivery if the write fails
+	valid, rejected, err := c.storeReadings(c.matchSubject(msg.Subject), j.readings)
+	if err != nil {
+		log.Printf("Failed to store %d readings from %s: %v", len(j.readings), msg.Subject, err)
+		c.nakMessage(msg)
+		return
+	}
+
+	// Report rejected readings without dropping the rest of the batch
+	if err := c.deadLetterReadings(msg.Subject, contentType, rejected); err != nil {
+		log.Printf("Failed to dead-letter readings from %s: %v", msg.Subject, err)
+		c.nakMessage(msg)
+		return
+	}
+	c.ackMessage(msg)
+
+	// Only now is a redelivery of the message a duplicate: one nak'd above still has
+	// rejected readings to dead-letter
+	c.recordStored(j.msgKey, nil)
+
+	for _, data := range valid {
+		c.checkAlerts(data)
+	}
+}
+
+// rejectJob hands a message that the worker pool turned away back to JetStream. MQTT
+// messages can't be handed back and are retried like a failed store instead.
+func (c *DataConsumer) rejectJob(j *job, reason string) {
+	stats := c.workerPool.Stats()
+	if j.mqttMsg != nil {
+		if reason == rejectShutdown {
+			// The client disconnects next, and the broker redelivers the message to the next session
+			return
+		}
+		log.Printf("Overload: %s, retrying MQTT message on %s later (queued %d, shed %d, rejected %d)",
+			reason, j.mqttMsg.Topic(), stats.QueueDepth, stats.Shed, stats.Rejected)
+		j.attempt++
+		c.retryMQTTMessage(j, errors.New(reason))
+		return
+	}
+	log.Printf("Overload: %s, redelivering message on %s later (queued %d, shed %d, rejected %d)",
+		reason, j.msg.Subject, stats.QueueDepth, stats.Shed, stats.Rejected)
+	c.nakMessage(j.msg)
+}
+
+// checkAlerts sends alerts for a stored reading
+func (c *DataConsumer) checkAlerts(data SensorData) {
+	// Workers check alerts concurrently, but the alert state is shared
+	c.alertMu.Lock()
+	defer c.alertMu.Unlock()
+
+	// Composite rules read the latest value of every sensor they combine
+	latest := false
+	for _, rule := range c.alertRules {
+		if c.latest != nil && rule.IsComposite() && rule.Reads(data) {
+			latest = c.latest.Add(data)
+			break
+		}
+	}
+
+	// Evaluate every rule that applies to the reading's sensor
+	now := time.Now()
+	changed := false
+	for _, rule := range c.alertRules {
+		switch {
+		case rule.IsComposite():
+			if latest && rule.Reads(data) && c.evaluateComposite(rule, data, now) {
+				changed = true
+			}
+		case rule.Selects(data) && c.evaluateAlert(rule, data, now):
+			changed = true
+		}
+	}
+	if c.rates != nil {
+		c.rates.Add(data)
+	}
+	if changed {
+		if err := c.saveAlertState(); err != nil {
+			log.Printf("Failed to save alert state: %v", err)
+		}
+	}
+}
+
+// SubscribeToSensors binds a durable JetStream consumer to all sensor topics
 func (c *DataConsumer) SubscribeToSensors() error {
-	// Subscribe to all sensor data
-	_, err := c.natsConn.Subscribe("sensors.>", c.MessageHandler)
+	if err := c.ensureConsumer(); err != nil {
+		return err
+	}
+
+	// Subscribe to every subject of the sensor stream with explicit acks
+	var err error
+	c.subscription, err = c.jetStream.Subscribe("", c.MessageHandler,
+		nats.Bind(c.jsStream, c.jsDurable),
+		nats.ManualAck(),
+	)
 	if err != nil {
 		return fmt.Errorf("error subscribing to topics: %w", err)
 	}
 
-	log.Println("Subscribed to all sensor topics")
+	log.Printf("Subscribed to all sensor topics on stream %s as durable %s", c.jsStream, c.jsDurable)
 	return nil
 }
 
@@ -183,16 +595,38 @@ func (c *DataConsumer) Run() error {
 func (c *DataConsumer) Shutdown() {
 	log.Println("Shutting down consumer service...")
 
-	// Flush any buffered points
-	if c.writeAPI != nil {
-		c.writeAPI.Flush()
+	// Stop JetStream deliveries, handing what was already delivered to the workers
+	if c.subscription != nil {
+		c.drainSubscription()
 	}
 
-	// Close clients
-	if c.influxClient != nil {
-		c.influxClient.Close()
+	// Stop accepting HTTP requests
+	if c.httpServer != nil {
+		c.stopHTTPServer()
+	}
+
+	// Let the workers finish the messages they already hold while the context is still live
+	if c.workerPool != nil {
+		c.workerPool.Stop()
 	}
 
+	// Stop taking MQTT messages once the queued ones have been acknowledged
+	if c.mqttClient != nil {
+		c.disconnectMQTT()
+	}
+
+	// Stop the background loops
+	c.cancelFunc()
+
+	// Flush and close the storage sinks
+	if c.sink != nil {
+		if err := c.sink.Close(); err != nil {
+			log.Printf("Failed to close storage sinks: %v", err)
+		}
+	}
+
+	// Close clients
+
 	if c.natsConn != nil {
 		c.natsConn.Close()
 	}
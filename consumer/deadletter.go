@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers attached to dead-lettered messages
+const (
+	HeaderDLQReason          = "Dlq-Reason"
+	HeaderDLQError           = "Dlq-Error"
+	HeaderDLQOriginalSubject = "Dlq-Original-Subject"
+	HeaderDLQItemIndex       = "Dlq-Item-Index"
+)
+
+// deadLetterHeaders are the headers of the original message carried over to its dead-letter
+// copy. Nats-Msg-Id in particular is left out: the dead-letter subjects share the sensor
+// stream, whose duplicate window would otherwise drop the copy as a repeat of the original.
+var deadLetterHeaders = []string{HeaderContentType, HeaderContentEncoding, HeaderTenantID}
+
+// isDeadLetterSubject reports whether a subject belongs to the dead-letter namespace
+func (c *DataConsumer) isDeadLetterSubject(subject string) bool {
+	return strings.HasPrefix(subject, c.dlqSubjectPrefix+".")
+}
+
+// rejectionReason returns the dead-letter reason for an error
+func rejectionReason(cause error) string {
+	var validationErr *ValidationError
+	if errors.As(cause, &validationErr) {
+		return validationErr.Reason
+	}
+	return ReasonDecode
+}
+
+// publishDeadLetter republishes the original payload to the dead-letter subject for the error's reason,
+// keeping the given headers that describe the payload. A negative index means the payload is the whole message rather than one
+// reading of a batch. The copy is published through JetStream, so a nil error means the stream stored it.
+func (c *DataConsumer) publishDeadLetter(subject string, payload []byte, header nats.Header, cause error, index int) error {
+	reason := rejectionReason(cause)
+
+	dlqMsg := nats.NewMsg(c.dlqSubjectPrefix + "." + reason)
+	dlqMsg.Data = payload
+	for _, key := range deadLetterHeaders {
+		if values := header.Values(key); len(values) > 0 {
+			dlqMsg.Header[key] = values
+		}
+	}
+	dlqMsg.Header.Set(HeaderDLQReason, reason)
+	dlqMsg.Header.Set(HeaderDLQError, cause.Error())
+	dlqMsg.Header.Set(HeaderDLQOriginalSubject, subject)
+	if index >= 0 {
+		dlqMsg.Header.Set(HeaderDLQItemIndex, strconv.Itoa(index))
+	}
+
+	_, err := c.jetStream.PublishMsg(dlqMsg)
+	return err
+}
+
+// rejectMessage dead-letters a message and, once the stream has stored the copy, stops its redelivery
+func (c *DataConsumer) rejectMessage(msg *nats.Msg, cause error) {
+	reason := rejectionReason(cause)
+
+	// Keep the message in JetStream if it could not be dead-lettered
+	if err := c.publishDeadLetter(msg.Subject, msg.Data, msg.Header, cause, -1); err != nil {
+		log.Printf("Failed to publish message on %s to dead-letter subject: %v", msg.Subject, err)
+		c.nakMessage(msg)
+		return
+	}
+
+	log.Printf("Dead-lettered message on %s (%s)", msg.Subject, reason)
+	c.termMessage(msg)
+}
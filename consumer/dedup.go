@@ -0,0 +1,252 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Deduplication cache modes
+const (
+	DedupModeNone   = "none"
+	DedupModeMemory = "memory"
+	DedupModeKV     = "kv"
+)
+
+// HeaderMsgID is the NATS header carrying the publisher's message ID
+const HeaderMsgID = "Nats-Msg-Id"
+
+// DedupCache remembers which messages and readings have already been stored
+type DedupCache interface {
+	// Contains reports whether a key was stored within the deduplication window
+	Contains(key string) (bool, error)
+	// Add records keys once the data they identify has been stored
+	Add(keys ...string) error
+}
+
+// messageDedupKey returns the deduplication key of a message, or "" if it has no message ID
+func messageDedupKey(msg *nats.Msg) string {
+	if id := msg.Header.Get(HeaderMsgID); id != "" {
+		return "msg:" + id
+	}
+	return ""
+}
+
+// readingDedupKey returns the deduplication key of a reading. Sensor IDs are only
+// unique within a tenant.
+func readingDedupKey(data SensorData) string {
+	key := "reading:" + data.SensorID + "|" + strconv.FormatInt(data.Timestamp.UnixNano(), 10)
+	if data.Tenant != "" {
+		key = data.Tenant + "|" + key
+	}
+	return key
+}
+
+// memoryDedupCache is a bounded in-memory DedupCache
+type memoryDedupCache struct {
+	mu         sync.Mutex
+	window     time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+}
+
+// dedupEntry is an element of the memory cache's insertion order
+type dedupEntry struct {
+	key    string
+	seenAt time.Time
+}
+
+// NewMemoryDedupCache creates an in-memory cache holding at most maxEntries keys for the given window
+func NewMemoryDedupCache(window time.Duration, maxEntries int) DedupCache {
+	return &memoryDedupCache{
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Contains implements DedupCache
+func (m *memoryDedupCache) Contains(key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.evictExpired(time.Now())
+	_, ok := m.entries[key]
+	return ok, nil
+}
+
+// Add implements DedupCache
+func (m *memoryDedupCache) Add(keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if element, ok := m.entries[key]; ok {
+			m.order.Remove(element)
+		}
+		m.entries[key] = m.order.PushBack(&dedupEntry{key: key, seenAt: now})
+	}
+
+	// Drop the oldest keys once the cache is full
+	for m.maxEntries > 0 && m.order.Len() > m.maxEntries {
+		m.remove(m.order.Front())
+	}
+	m.evictExpired(now)
+	return nil
+}
+
+// evictExpired drops keys older than the window
+func (m *memoryDedupCache) evictExpired(now time.Time) {
+	for element := m.order.Front(); element != nil; element = m.order.Front() {
+		if now.Sub(element.Value.(*dedupEntry).seenAt) < m.window {
+			return
+		}
+		m.remove(element)
+	}
+}
+
+// remove drops a single key
+func (m *memoryDedupCache) remove(element *list.Element) {
+	delete(m.entries, element.Value.(*dedupEntry).key)
+	m.order.Remove(element)
+}
+
+// kvDedupCache is a DedupCache backed by a NATS KV bucket whose TTL is the deduplication window,
+// so that several consumer instances share it and it survives restarts
+type kvDedupCache struct {
+	kv nats.KeyValue
+}
+
+// NewKVDedupCache opens or creates the KV bucket used for deduplication
+func NewKVDedupCache(js nats.JetStreamContext, bucket string, window time.Duration) (DedupCache, error) {
+	kv, err := js.KeyValue(bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "Recently stored sensor messages and readings",
+			TTL:         window,
+			Storage:     nats.FileStorage,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open deduplication bucket %s: %w", bucket, err)
+	}
+	return &kvDedupCache{kv: kv}, nil
+}
+
+// kvKey hashes a key into the character set allowed for KV keys
+func kvKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Contains implements DedupCache
+func (k *kvDedupCache) Contains(key string) (bool, error) {
+	_, err := k.kv.Get(kvKey(key))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Add implements DedupCache
+func (k *kvDedupCache) Add(keys ...string) error {
+	for _, key := range keys {
+		if _, err := k.kv.Put(kvKey(key), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDedupCache creates the deduplication cache selected by the configuration
+func (c *DataConsumer) newDedupCache() (DedupCache, error) {
+	switch c.dedupMode {
+	case DedupModeNone:
+		return nil, nil
+	case DedupModeMemory:
+		return NewMemoryDedupCache(c.dedupWindow, c.dedupMaxEntries), nil
+	case DedupModeKV:
+		return NewKVDedupCache(c.jetStream, c.dedupKVBucket, c.dedupWindow)
+	default:
+		return nil, fmt.Errorf("invalid deduplication mode %q", c.dedupMode)
+	}
+}
+
+// isDuplicate reports whether a key was already stored. Cache errors are logged
+// and treated as "not seen" so that data is never dropped because of them.
+func (c *DataConsumer) isDuplicate(key string) bool {
+	if c.dedupCache == nil || key == "" {
+		return false
+	}
+
+	seen, err := c.dedupCache.Contains(key)
+	if err != nil {
+		log.Printf("Failed to check deduplication cache: %v", err)
+		return false
+	}
+	return seen
+}
+
+// recordDuplicate counts and logs a suppressed duplicate
+func (c *DataConsumer) recordDuplicate(what string) {
+	total := c.duplicates.Add(1)
+	duplicatesDropped.Inc()
+	log.Printf("Suppressed duplicate %s (%d duplicates so far)", what, total)
+}
+
+// dropDuplicateReadings removes readings that were already stored or appear twice in the same batch
+func (c *DataConsumer) dropDuplicateReadings(readings []SensorData) []SensorData {
+	if c.dedupCache == nil {
+		return readings
+	}
+
+	unique := readings[:0]
+	inBatch := make(map[string]bool, len(readings))
+	for _, data := range readings {
+		key := readingDedupKey(data)
+		if inBatch[key] || c.isDuplicate(key) {
+			c.recordDuplicate(fmt.Sprintf("reading from sensor %s at %s", data.SensorID, data.Timestamp.Format(time.RFC3339Nano)))
+			continue
+		}
+		inBatch[key] = true
+		unique = append(unique, data)
+	}
+	return unique
+}
+
+// recordStored remembers a stored message and its readings for deduplication
+func (c *DataConsumer) recordStored(msgKey string, readings []SensorData) {
+	if c.dedupCache == nil {
+		return
+	}
+
+	keys := make([]string, 0, len(readings)+1)
+	if msgKey != "" {
+		keys = append(keys, msgKey)
+	}
+	for _, data := range readings {
+		keys = append(keys, readingDedupKey(data))
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	if err := c.dedupCache.Add(keys...); err != nil {
+		log.Printf("Failed to update deduplication cache: %v", err)
+	}
+}
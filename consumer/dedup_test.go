@@ -0,0 +1,120 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupCacheEviction(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxEntries int
+		// adds are the batches of keys added in turn
+		adds        [][]string
+		wantPresent []string
+		wantEvicted []string
+	}{
+		{
+			name:        "below the cap",
+			maxEntries:  3,
+			adds:        [][]string{{"a"}, {"b"}, {"c"}},
+			wantPresent: []string{"a", "b", "c"},
+		},
+		{
+			name:        "oldest key goes first",
+			maxEntries:  3,
+			adds:        [][]string{{"a"}, {"b"}, {"c"}, {"d"}},
+			wantPresent: []string{"b", "c", "d"},
+			wantEvicted: []string{"a"},
+		},
+		{
+			name:        "adding a key again makes it the newest",
+			maxEntries:  3,
+			adds:        [][]string{{"a"}, {"b"}, {"c"}, {"a"}, {"d"}},
+			wantPresent: []string{"a", "c", "d"},
+			wantEvicted: []string{"b"},
+		},
+		{
+			name:        "batch larger than the cap keeps its last keys",
+			maxEntries:  2,
+			adds:        [][]string{{"a", "b", "c", "d"}},
+			wantPresent: []string{"c", "d"},
+			wantEvicted: []string{"a", "b"},
+		},
+		{
+			name:        "no cap",
+			maxEntries:  0,
+			adds:        [][]string{{"a", "b", "c", "d"}},
+			wantPresent: []string{"a", "b", "c", "d"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewMemoryDedupCache(time.Hour, tt.maxEntries)
+			for _, keys := range tt.adds {
+				if err := cache.Add(keys...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, key := range tt.wantPresent {
+				if ok, _ := cache.Contains(key); !ok {
+					t.Errorf("key %s was evicted", key)
+				}
+			}
+			for _, key := range tt.wantEvicted {
+				if ok, _ := cache.Contains(key); ok {
+					t.Errorf("key %s is still cached", key)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryDedupCacheWindow(t *testing.T) {
+	const window = 200 * time.Millisecond
+	cache := NewMemoryDedupCache(window, 0)
+
+	cache.Add("old")
+	time.Sleep(window / 2)
+	cache.Add("new")
+	if ok, _ := cache.Contains("old"); !ok {
+		t.Error("key expired within the window")
+	}
+
+	time.Sleep(window/2 + window/5)
+	if ok, _ := cache.Contains("old"); ok {
+		t.Error("key is still cached after the window")
+	}
+	if ok, _ := cache.Contains("new"); !ok {
+		t.Error("newer key expired with the older one")
+	}
+}
+
+func TestDropDuplicateReadings(t *testing.T) {
+	timestamp := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	reading := func(tenant, sensorID string, value float64) SensorData {
+		return SensorData{SensorType: "temperature", SensorID: sensorID, Tenant: tenant, Value: value, Timestamp: timestamp}
+	}
+
+	c := &DataConsumer{dedupCache: NewMemoryDedupCache(time.Hour, 100)}
+	c.recordStored("msg:1", []SensorData{reading("", "temp_001", 20)})
+
+	unique := c.dropDuplicateReadings([]SensorData{
+		reading("", "temp_001", 20),     // stored before
+		reading("", "temp_002", 21),     // new
+		reading("", "temp_002", 21.5),   // same sensor and time in the same batch
+		reading("acme", "temp_001", 20), // same sensor ID, other tenant
+	})
+	want := []SensorData{reading("", "temp_002", 21), reading("acme", "temp_001", 20)}
+	if !reflect.DeepEqual(unique, want) {
+		t.Errorf("got %+v, want %+v", unique, want)
+	}
+	if !c.isDuplicate("msg:1") || c.isDuplicate("msg:2") || c.isDuplicate("") {
+		t.Error("message keys are not recorded as stored")
+	}
+	if c.duplicates.Load() != 2 {
+		t.Errorf("counted %d duplicates, want 2", c.duplicates.Load())
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagQuality marks readings whose value should not be trusted
+const TagQuality = "quality"
+
+// QualityStuck marks readings of a sensor that keeps reporting the same value
+const QualityStuck = "stuck"
+
+// StatusStuck is published on <status prefix>.stuck when a sensor flatlines
+const StatusStuck = "stuck"
+
+// FlatlineRule defines when a run of readings of one sensor type counts as stuck: every
+// value stays within Tolerance of the first value of the run for at least MinDuration
+type FlatlineRule struct {
+	Tolerance   float64
+	MinDuration time.Duration
+}
+
+// ParseFlatlineRules parses a comma-separated list of type=tolerance/duration entries,
+// e.g. "temperature=0.01/30m"
+func ParseFlatlineRules(list string) (map[string]FlatlineRule, error) {
+	rules := make(map[string]FlatlineRule)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sensorType, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid flatline rule %q, expected type=tolerance/duration", entry)
+		}
+		tolerance, duration, ok := strings.Cut(value, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid flatline rule %q, expected type=tolerance/duration", entry)
+		}
+
+		var rule FlatlineRule
+		var err error
+		rule.Tolerance, err = strconv.ParseFloat(strings.TrimSpace(tolerance), 64)
+		if err != nil || rule.Tolerance < 0 {
+			return nil, fmt.Errorf("invalid flatline tolerance in %q", entry)
+		}
+		rule.MinDuration, err = time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || rule.MinDuration <= 0 {
+			return nil, fmt.Errorf("invalid flatline duration in %q", entry)
+		}
+		rules[strings.TrimSpace(sensorType)] = rule
+	}
+	return rules, nil
+}
+
+// StuckEvent announces that a sensor has reported the same value for too long
+type StuckEvent struct {
+	Status     string    `json:"status"`
+	SensorID   string    `json:"sensorId"`
+	SensorType string    `json:"sensorType"`
+	Location   string    `json:"location"`
+	Tenant     string    `json:"tenant,omitempty"`
+	Value      float64   `json:"value"`
+	Since      time.Time `json:"since"`
+	Time       time.Time `json:"time"`
+}
+
+// valueRun is the current run of near-identical values of one sensor
+type valueRun struct {
+	value float64
+	start time.Time
+	last  time.Time
+	stuck bool
+}
+
+// FlatlineDetector follows the runs of near-identical values of every sensor
+type FlatlineDetector struct {
+	rules map[string]FlatlineRule
+
+	mu   sync.Mutex
+	runs map[string]*valueRun
+}
+
+// NewFlatlineDetector creates a detector for the given per-type rules
+func NewFlatlineDetector(rules map[string]FlatlineRule) *FlatlineDetector {
+	return &FlatlineDetector{
+		rules: rules,
+		runs:  make(map[string]*valueRun),
+	}
+}
+
+// Check tags readings that belong to a stuck run and returns an event for every sensor
+// that became stuck. Readings older than the latest one seen for their sensor don't move the run,
+// so redelivered readings are tagged the same way again.
+func (d *FlatlineDetector) Check(batch []SensorData) ([]SensorData, []StuckEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var events []StuckEvent
+	for i, data := range batch {
+		rule, ok := d.rules[data.SensorType]
+		if !ok {
+			continue
+		}
+
+		key := sensorKey(data)
+		run := d.runs[key]
+		inRun := run != nil && math.Abs(data.Value-run.value) <= rule.Tolerance
+		switch {
+		case run != nil && !data.Timestamp.After(run.last):
+			// Out of order or redelivered; judge it against the current run only
+		case inRun:
+			run.last = data.Timestamp
+			if !run.stuck && run.last.Sub(run.start) >= rule.MinDuration {
+				run.stuck = true
+				events = append(events, StuckEvent{
+					Status:     StatusStuck,
+					SensorID:   data.SensorID,
+					SensorType: data.SensorType,
+					Location:   data.Location,
+					Tenant:     data.Tenant,
+					Value:      run.value,
+					Since:      run.start,
+					Time:       data.Timestamp,
+				})
+			}
+		default:
+			if run != nil && run.stuck {
+				log.Printf("Sensor %s is no longer stuck at %v", data.SensorID, run.value)
+			}
+			run = &valueRun{value: data.Value, start: data.Timestamp, last: data.Timestamp}
+			d.runs[key] = run
+			inRun = true
+		}
+
+		if inRun && run.stuck {
+			batch[i] = data.WithTag(TagQuality, QualityStuck)
+		}
+	}
+	return batch, events
+}
+
+// publishStuck publishes stuck events
+func (c *DataConsumer) publishStuck(events []StuckEvent) {
+	for _, event := range events {
+		log.Printf("Sensor %s is stuck at %v since %s", event.SensorID, event.Value, event.Since.Format(time.RFC3339))
+
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("Failed to encode stuck event: %v", err)
+			continue
+		}
+		if err := c.natsConn.Publish(c.statusSubjectPrefix+"."+StatusStuck, payload); err != nil {
+			log.Printf("Failed to publish stuck event for sensor %s: %v", event.SensorID, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlatlineCheck(t *testing.T) {
+	rules := map[string]FlatlineRule{"temperature": {Tolerance: 0.05, MinDuration: 3 * time.Minute}}
+
+	tests := []struct {
+		name string
+		// values are read once a minute
+		values []float64
+		// wantStuck marks the readings that are tagged as stuck
+		wantStuck []bool
+		// wantEvent is the index of the reading that announces the sensor as stuck, or -1
+		wantEvent int
+	}{
+		{
+			name:      "changing values",
+			values:    []float64{20, 20.5, 21, 21.5, 22},
+			wantStuck: []bool{false, false, false, false, false},
+			wantEvent: -1,
+		},
+		{
+			name:      "constant for the minimum duration",
+			values:    []float64{20, 20, 20, 20, 20},
+			wantStuck: []bool{false, false, false, true, true},
+			wantEvent: 3,
+		},
+		{
+			name:      "noise within the tolerance",
+			values:    []float64{20, 20.04, 19.96, 20.02},
+			wantStuck: []bool{false, false, false, true},
+			wantEvent: 3,
+		},
+		{
+			name:      "change beyond the tolerance starts a new run",
+			values:    []float64{20, 20, 20, 20.1, 20.1, 20.1},
+			wantStuck: []bool{false, false, false, false, false, false},
+			wantEvent: -1,
+		},
+		{
+			name:      "recovers after being stuck",
+			values:    []float64{20, 20, 20, 20, 25, 25},
+			wantStuck: []bool{false, false, false, true, false, false},
+			wantEvent: 3,
+		},
+	}
+
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewFlatlineDetector(rules)
+			event := -1
+			for i, value := range tt.values {
+				data := SensorData{SensorType: "temperature", SensorID: "temp_001", Value: value, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+				checked, events := detector.Check([]SensorData{data})
+				if stuck := checked[0].Tags[TagQuality] == QualityStuck; stuck != tt.wantStuck[i] {
+					t.Errorf("reading %d (%v): got stuck %v, want %v", i, value, stuck, tt.wantStuck[i])
+				}
+				if len(events) > 0 {
+					if event >= 0 {
+						t.Errorf("reading %d announced the sensor as stuck again", i)
+					}
+					event = i
+					if !events[0].Since.Equal(start) {
+						t.Errorf("got stuck since %v, want %v", events[0].Since, start)
+					}
+				}
+			}
+			if event != tt.wantEvent {
+				t.Errorf("got the stuck event at reading %d, want %d", event, tt.wantEvent)
+			}
+		})
+	}
+}
+
+func TestFlatlineCheckIgnoresOtherTypesAndRedeliveries(t *testing.T) {
+	detector := NewFlatlineDetector(map[string]FlatlineRule{"temperature": {Tolerance: 0, MinDuration: time.Minute}})
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	reading := func(sensorType string, minute int) SensorData {
+		return SensorData{SensorType: sensorType, SensorID: sensorType + "_001", Value: 50, Timestamp: start.Add(time.Duration(minute) * time.Minute)}
+	}
+
+	// Humidity has no rule and is never stuck
+	batch, events := detector.Check([]SensorData{reading("humidity", 0), reading("humidity", 5)})
+	if len(events) != 0 || batch[1].Tags[TagQuality] == QualityStuck {
+		t.Errorf("humidity without a rule was judged stuck")
+	}
+
+	// A redelivered reading is tagged again but announces nothing new
+	detector.Check([]SensorData{reading("temperature", 0), reading("temperature", 1)})
+	batch, events = detector.Check([]SensorData{reading("temperature", 1)})
+	if len(events) != 0 || batch[0].Tags[TagQuality] != QualityStuck {
+		t.Errorf("got %d events and quality %q for a redelivery, want none and %q", len(events), batch[0].Tags[TagQuality], QualityStuck)
+	}
+}
+
+func TestParseFlatlineRules(t *testing.T) {
+	rules, err := ParseFlatlineRules(" temperature=0.01/30m, humidity = 0.5/1h ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]FlatlineRule{
+		"temperature": {Tolerance: 0.01, MinDuration: 30 * time.Minute},
+		"humidity":    {Tolerance: 0.5, MinDuration: time.Hour},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for sensorType, rule := range want {
+		if rules[sensorType] != rule {
+			t.Errorf("%s: got %+v, want %+v", sensorType, rules[sensorType], rule)
+		}
+	}
+
+	for _, list := range []string{"temperature", "temperature=0.01", "temperature=-1/30m", "temperature=0.01/0s", "temperature=x/30m"} {
+		if _, err := ParseFlatlineRules(list); err == nil {
+			t.Errorf("%q: expected an error", list)
+		}
+	}
+}
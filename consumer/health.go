@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// healthCheckTimeout bounds the dependency checks of a readiness probe
+const healthCheckTimeout = 5 * time.Second
+
+// HealthResponse is the body of /healthz and /readyz
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// handleHealthz reports that the process is alive
+func (c *DataConsumer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// handleReadyz reports whether the consumer can take readings: NATS is connected, the
+// storage sinks answer and a reading has been stored recently
+func (c *DataConsumer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"nats":      c.checkNATS(),
+		"lastWrite": c.checkLastWrite(),
+	}
+	if checker, ok := c.sink.(HealthChecker); ok {
+		checks["sinks"] = checker.Check(ctx)
+	}
+
+	response := HealthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	status := http.StatusOK
+	for name, err := range checks {
+		response.Checks[name] = "ok"
+		if err != nil {
+			response.Checks[name] = err.Error()
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, response)
+}
+
+// checkNATS reports whether the NATS connection is up
+func (c *DataConsumer) checkNATS() error {
+	if c.natsConn == nil {
+		return fmt.Errorf("not connected")
+	}
+	if status := c.natsConn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("connection is %s", status)
+	}
+	return nil
+}
+
+// checkLastWrite reports whether a reading was stored within readyMaxWriteAge
+func (c *DataConsumer) checkLastWrite() error {
+	if c.readyMaxWriteAge <= 0 {
+		return nil
+	}
+	age := time.Since(time.Unix(0, c.lastWrite.Load()))
+	if age > c.readyMaxWriteAge {
+		return fmt.Errorf("no reading stored for %s", age.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Ingestion modes of the HTTP endpoint
+const (
+	IngestModeSync  = "sync"
+	IngestModeAsync = "async"
+)
+
+// HeaderIdempotencyKey lets HTTP clients make retried requests safe to repeat
+const HeaderIdempotencyKey = "Idempotency-Key"
+
+// Per-item statuses reported by the HTTP endpoint
+const (
+	ItemStatusStored    = "stored"
+	ItemStatusAccepted  = "accepted"
+	ItemStatusDuplicate = "duplicate"
+	ItemStatusRejected  = "rejected"
+)
+
+// IngestItemResult is the outcome for one reading of an HTTP request
+type IngestItemResult struct {
+	Index    int    `json:"index"`
+	Status   string `json:"status"`
+	SensorID string `json:"sensorId,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// IngestResponse is the body returned by POST /v1/readings
+type IngestResponse struct {
+	Mode       string             `json:"mode"`
+	Accepted   int                `json:"accepted"`
+	Duplicates int                `json:"duplicates"`
+	Rejected   int                `json:"rejected"`
+	Results    []IngestItemResult `json:"results,omitempty"`
+	Error      string             `json:"error,omitempty"`
+}
+
+// startHTTPServer serves the HTTP ingestion endpoint
+func (c *DataConsumer) startHTTPServer() error {
+	if c.httpIngestMode != IngestModeSync && c.httpIngestMode != IngestModeAsync {
+		return fmt.Errorf("invalid HTTP ingest mode %q", c.httpIngestMode)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", c.handleHealthz)
+	mux.HandleFunc("/readyz", c.handleReadyz)
+	if len(c.httpAuthTokens) > 0 {
+		mux.HandleFunc("/v1/readings", c.authenticate(c.handleIngest))
+	} else {
+		log.Println("Warning: HTTP_AUTH_TOKENS is empty, the HTTP ingestion endpoint is disabled")
+	}
+
+	c.httpServer = &http.Server{
+		Addr:              c.httpAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := c.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server failed: %v", err)
+		}
+	}()
+
+	log.Printf("Serving HTTP on %s (ingest mode: %s)", c.httpAddr, c.httpIngestMode)
+	return nil
+}
+
+// authenticate only lets requests with a configured bearer token through
+func (c *DataConsumer) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, allowed := range c.httpAuthTokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+					next(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="readings"`)
+		writeJSON(w, http.StatusUnauthorized, IngestResponse{Mode: c.httpIngestMode, Error: "missing or invalid bearer token"})
+	}
+}
+
+// handleIngest accepts one reading or a batch of readings in any supported encoding.
+// Readings go through the same validation as NATS messages. In sync mode they are
+// written to the sinks before the response is sent; in async mode the request is
+// published to JetStream and stored by the consumer like any other message.
+func (c *DataConsumer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, IngestResponse{Mode: c.httpIngestMode, Error: "only POST is supported"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(c.httpMaxBodyBytes)))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, IngestResponse{Mode: c.httpIngestMode, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, IngestResponse{Mode: c.httpIngestMode, Error: err.Error()})
+		return
+	}
+
+	messagesReceived.WithLabelValues(SourceHTTP).Inc()
+	contentType := r.Header.Get(HeaderContentType)
+	contentEncoding := r.Header.Get(HeaderContentEncoding)
+	readings, err := DecodeReadings(body, contentType, contentEncoding)
+	if err != nil {
+		decodeFailures.Inc()
+		status := http.StatusBadRequest
+		if rejectionReason(err) == ReasonUnsupportedFormat {
+			status = http.StatusUnsupportedMediaType
+		}
+		writeJSON(w, status, IngestResponse{Mode: c.httpIngestMode, Error: err.Error()})
+		return
+	}
+
+	tenant := r.Header.Get(HeaderTenantID)
+	applyTenantHeader(readings, tenant)
+
+	// Validate each reading on its own so that results can be reported per item
+	resp := IngestResponse{Mode: c.httpIngestMode, Results: make([]IngestItemResult, len(readings))}
+	var valid []SensorData
+	var validIndexes []int
+	for i, reading := range readings {
+		result := IngestItemResult{Index: reading.Index, SensorID: reading.Data.SensorID}
+		prepared, rejected := c.prepareReadings(nil, []DecodedReading{reading})
+		if len(rejected) > 0 {
+			result.Status = ItemStatusRejected
+			result.Reason = rejectionReason(rejected[0].Err)
+			result.Error = rejected[0].Err.Error()
+			resp.Rejected++
+		} else {
+			valid = append(valid, prepared[0])
+			validIndexes = append(validIndexes, i)
+		}
+		resp.Results[i] = result
+	}
+
+	if len(valid) > 0 {
+		var err error
+		if c.httpIngestMode == IngestModeAsync {
+			err = c.publishIngest(r, body, contentType, contentEncoding, &resp, validIndexes)
+		} else {
+			err = c.storeIngest(valid, &resp, validIndexes)
+		}
+		if err != nil {
+			log.Printf("Failed to ingest %d readings over HTTP: %v", len(valid), err)
+			resp.Error = err.Error()
+			writeJSON(w, http.StatusServiceUnavailable, resp)
+			return
+		}
+	}
+
+	writeJSON(w, ingestStatus(&resp), resp)
+}
+
+// storeIngest writes validated readings to the sinks like readings of a message, skipping
+// readings that were already stored
+func (c *DataConsumer) storeIngest(valid []SensorData, resp *IngestResponse, indexes []int) error {
+	unique, err := c.storeValid(append([]SensorData(nil), valid...))
+	if err != nil {
+		return err
+	}
+
+	// Readings that survived deduplication were stored, in their original order
+	stored := make(map[string]int, len(unique))
+	for _, data := range unique {
+		stored[readingDedupKey(data)]++
+	}
+	for i, data := range valid {
+		result := &resp.Results[indexes[i]]
+		key := readingDedupKey(data)
+		if stored[key] > 0 {
+			stored[key]--
+			result.Status = ItemStatusStored
+			resp.Accepted++
+		} else {
+			result.Status = ItemStatusDuplicate
+			resp.Duplicates++
+		}
+	}
+
+	for _, data := range unique {
+		c.checkAlerts(data)
+	}
+	return nil
+}
+
+// publishIngest hands the request body to JetStream for the consumer to store. Rejected
+// readings are dead-lettered on that path just like readings published over NATS.
+func (c *DataConsumer) publishIngest(r *http.Request, body []byte, contentType, contentEncoding string, resp *IngestResponse, indexes []int) error {
+	msg := nats.NewMsg(c.httpIngestSubject)
+	msg.Data = body
+	if contentType != "" {
+		msg.Header.Set(HeaderContentType, contentType)
+	}
+	if contentEncoding != "" {
+		msg.Header.Set(HeaderContentEncoding, contentEncoding)
+	}
+	if tenant := r.Header.Get(HeaderTenantID); tenant != "" {
+		msg.Header.Set(HeaderTenantID, tenant)
+	}
+	if key := r.Header.Get(HeaderIdempotencyKey); key != "" {
+		msg.Header.Set(HeaderMsgID, key)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	if _, err := c.jetStream.PublishMsg(msg, nats.Context(ctx)); err != nil {
+		return fmt.Errorf("failed to publish to JetStream: %w", err)
+	}
+
+	for _, i := range indexes {
+		resp.Results[i].Status = ItemStatusAccepted
+		resp.Accepted++
+	}
+	return nil
+}
+
+// ingestStatus returns the HTTP status for a processed request
+func ingestStatus(resp *IngestResponse) int {
+	switch {
+	case resp.Rejected == 0 && resp.Mode == IngestModeAsync:
+		return http.StatusAccepted
+	case resp.Rejected == 0:
+		return http.StatusOK
+	case resp.Accepted+resp.Duplicates > 0:
+		return http.StatusMultiStatus
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", ContentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write HTTP response: %v", err)
+	}
+}
+
+// stopHTTPServer stops accepting requests and waits for running ones to finish
+func (c *DataConsumer) stopHTTPServer() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop HTTP server: %v", err)
+	}
+}
+
+// parseTokens splits a comma-separated list of tokens
+func parseTokens(list string) []string {
+	var tokens []string
+	for _, token := range strings.Split(list, ",") {
+		if token = strings.TrimSpace(token); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
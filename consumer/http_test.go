@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps every batch written to it and fails while err is set
+type recordingSink struct {
+	name    string
+	err     error
+	batches [][]SensorData
+}
+
+func (s *recordingSink) Name() string { return s.name }
+
+func (s *recordingSink) Write(_ context.Context, batch []SensorData) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]SensorData(nil), batch...))
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestStoreIngestTagsQuality(t *testing.T) {
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	sink := &recordingSink{name: "memory"}
+	c := &DataConsumer{
+		ctx:        context.Background(),
+		sink:       sink,
+		dedupCache: NewMemoryDedupCache(time.Hour, 100),
+		flatlines:  NewFlatlineDetector(map[string]FlatlineRule{"temperature": {Tolerance: 0.01, MinDuration: 2 * time.Minute}}),
+		outliers:   NewOutlierDetector(10, 3, 3, 5),
+	}
+
+	// A gateway that keeps sending 20 °C, then jumps to 35 °C, sent twice
+	values := []float64{20, 20, 20, 20, 35, 35}
+	valid := make([]SensorData, len(values))
+	indexes := make([]int, len(values))
+	for i, value := range values {
+		valid[i] = SensorData{SensorType: "temperature", SensorID: "temp_001", Value: value, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		indexes[i] = i
+	}
+	valid[5].Timestamp = valid[4].Timestamp
+
+	resp := IngestResponse{Results: make([]IngestItemResult, len(valid))}
+	if err := c.storeIngest(valid, &resp, indexes); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sink.batches) != 1 {
+		t.Fatalf("got %d batches, want 1", len(sink.batches))
+	}
+	want := []string{QualityGood, QualityGood, QualityStuck, QualityStuck, QualitySuspect}
+	stored := sink.batches[0]
+	if len(stored) != len(want) {
+		t.Fatalf("stored %d readings, want %d", len(stored), len(want))
+	}
+	for i, data := range stored {
+		if quality := data.Tags[TagQuality]; quality != want[i] {
+			t.Errorf("reading %d (%v): got quality %q, want %q", i, data.Value, quality, want[i])
+		}
+	}
+	if resp.Accepted != 5 || resp.Duplicates != 1 || resp.Results[5].Status != ItemStatusDuplicate {
+		t.Errorf("got %d accepted and %d duplicates, want 5 and 1", resp.Accepted, resp.Duplicates)
+	}
+}
+
+func TestHandleIngest(t *testing.T) {
+	reading := func(sensorID string, value string) string {
+		return `{"sensorType": "temperature", "sensorId": "` + sensorID + `", "value": ` + value + `, "unit": "°C", "timestamp": "2025-05-12T10:00:00Z"}`
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		token        string
+		body         string
+		wantStatus   int
+		wantStatuses []string
+	}{
+		{
+			name:       "missing token",
+			method:     http.MethodPost,
+			body:       reading("temp_001", "21.5"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong token",
+			method:     http.MethodPost,
+			token:      "guess",
+			body:       reading("temp_001", "21.5"),
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "not a POST",
+			method:     http.MethodGet,
+			token:      "secret",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:         "undecodable reading",
+			method:       http.MethodPost,
+			token:        "secret",
+			body:         `{"sensorId": `,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantStatuses: []string{ItemStatusRejected},
+		},
+		{
+			name:         "single reading",
+			method:       http.MethodPost,
+			token:        "secret",
+			body:         reading("temp_001", "21.5"),
+			wantStatus:   http.StatusOK,
+			wantStatuses: []string{ItemStatusStored},
+		},
+		{
+			name:         "batch with a duplicate",
+			method:       http.MethodPost,
+			token:        "other",
+			body:         "[" + reading("temp_001", "21.5") + "," + reading("temp_002", "22") + "," + reading("temp_001", "21.5") + "]",
+			wantStatus:   http.StatusOK,
+			wantStatuses: []string{ItemStatusStored, ItemStatusStored, ItemStatusDuplicate},
+		},
+		{
+			name:         "partly rejected",
+			method:       http.MethodPost,
+			token:        "secret",
+			body:         "[" + reading("temp_001", "21.5") + "," + reading("temp_002", "900") + "]",
+			wantStatus:   http.StatusMultiStatus,
+			wantStatuses: []string{ItemStatusStored, ItemStatusRejected},
+		},
+		{
+			name:         "all rejected",
+			method:       http.MethodPost,
+			token:        "secret",
+			body:         "[" + reading("", "21.5") + "," + reading("temp_002", "900") + "]",
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantStatuses: []string{ItemStatusRejected, ItemStatusRejected},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{name: "memory"}
+			c := &DataConsumer{
+				ctx:              context.Background(),
+				sink:             sink,
+				dedupCache:       NewMemoryDedupCache(time.Hour, 100),
+				flatlines:        NewFlatlineDetector(nil),
+				httpIngestMode:   IngestModeSync,
+				httpAuthTokens:   []string{"secret", "other"},
+				httpMaxBodyBytes: 1 << 20,
+			}
+
+			req := httptest.NewRequest(tt.method, "/v1/readings", strings.NewReader(tt.body))
+			req.Header.Set(HeaderContentType, ContentTypeJSON)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			c.authenticate(c.handleIngest)(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			var resp IngestResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			var statuses []string
+			for _, result := range resp.Results {
+				statuses = append(statuses, result.Status)
+			}
+			if !reflect.DeepEqual(statuses, tt.wantStatuses) {
+				t.Errorf("got item statuses %v, want %v", statuses, tt.wantStatuses)
+			}
+
+			stored := 0
+			for _, batch := range sink.batches {
+				stored += len(batch)
+			}
+			if stored != resp.Accepted {
+				t.Errorf("stored %d readings but accepted %d", stored, resp.Accepted)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// sensorSubjects is the subject filter covering all sensor readings
+const sensorSubjects = "sensors.>"
+
+// subscriptionDrainTimeout bounds how long shutdown waits for delivered messages to be handled
+const subscriptionDrainTimeout = 10 * time.Second
+
+// ensureStream creates the sensor stream if it does not exist yet and makes
+// sure it captures the subjects of every configured subject template
+func (c *DataConsumer) ensureStream() error {
+	subjects := c.streamSubjects()
+
+	info, err := c.jetStream.StreamInfo(c.jsStream)
+	if err == nil {
+		missing := missingSubjects(info.Config.Subjects, subjects)
+		if len(missing) == 0 {
+			log.Printf("Using existing JetStream stream %s", c.jsStream)
+			return nil
+		}
+
+		config := info.Config
+		config.Subjects = append(config.Subjects, missing...)
+		if _, err := c.jetStream.UpdateStream(&config); err != nil {
+			return fmt.Errorf("failed to add subjects %v to stream %s: %w", missing, c.jsStream, err)
+		}
+		log.Printf("Added subjects %v to JetStream stream %s", missing, c.jsStream)
+		return nil
+	}
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("failed to look up stream %s: %w", c.jsStream, err)
+	}
+
+	// Create a file-backed stream so readings survive consumer restarts
+	_, err = c.jetStream.AddStream(&nats.StreamConfig{
+		Name:     c.jsStream,
+		Subjects: subjects,
+		Storage:  nats.FileStorage,
+		MaxAge:   c.jsMaxAge,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stream %s: %w", c.jsStream, err)
+	}
+
+	log.Printf("Created JetStream stream %s for %v", c.jsStream, subjects)
+	return nil
+}
+
+// ensureConsumer creates the durable consumer of the sensor stream, or applies the configured
+// delivery limits to an existing one. The subscription binds to it rather than letting the
+// client create it, because the client deletes consumers it created when a subscription is
+// drained, which would lose the consumer's position in the stream on every shutdown.
+func (c *DataConsumer) ensureConsumer() error {
+	info, err := c.jetStream.ConsumerInfo(c.jsStream, c.jsDurable)
+	if err == nil {
+		config := info.Config
+		config.AckWait = c.jsAckWait
+		config.MaxDeliver = c.jsMaxDeliver
+		config.MaxAckPending = c.jsMaxAckPending
+		if _, err := c.jetStream.UpdateConsumer(c.jsStream, &config); err != nil {
+			return fmt.Errorf("failed to update consumer %s: %w", c.jsDurable, err)
+		}
+		log.Printf("Using existing JetStream consumer %s", c.jsDurable)
+		return nil
+	}
+	if !errors.Is(err, nats.ErrConsumerNotFound) {
+		return fmt.Errorf("failed to look up consumer %s: %w", c.jsDurable, err)
+	}
+
+	_, err = c.jetStream.AddConsumer(c.jsStream, &nats.ConsumerConfig{
+		Durable:        c.jsDurable,
+		DeliverSubject: nats.NewInbox(),
+		DeliverPolicy:  nats.DeliverAllPolicy,
+		AckPolicy:      nats.AckExplicitPolicy,
+		AckWait:        c.jsAckWait,
+		MaxDeliver:     c.jsMaxDeliver,
+		MaxAckPending:  c.jsMaxAckPending,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create consumer %s: %w", c.jsDurable, err)
+	}
+
+	log.Printf("Created JetStream consumer %s on stream %s", c.jsDurable, c.jsStream)
+	return nil
+}
+
+// drainSubscription stops JetStream deliveries and waits, up to subscriptionDrainTimeout,
+// for the handler to take the messages the client already received
+func (c *DataConsumer) drainSubscription() {
+	if err := c.subscription.Drain(); err != nil {
+		log.Printf("Failed to drain subscription: %v", err)
+		return
+	}
+
+	deadline := time.Now().Add(subscriptionDrainTimeout)
+	for c.subscription.IsValid() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// missingSubjects returns the wanted subjects that are not in the existing list
+func missingSubjects(existing, wanted []string) []string {
+	have := make(map[string]bool, len(existing))
+	for _, subject := range existing {
+		have[subject] = true
+	}
+
+	var missing []string
+	for _, subject := range wanted {
+		if !have[subject] {
+			missing = append(missing, subject)
+		}
+	}
+	return missing
+}
+
+// ackMessage acknowledges a message once it has been fully processed
+func (c *DataConsumer) ackMessage(msg *nats.Msg) {
+	if err := msg.Ack(); err != nil {
+		log.Printf("Failed to ack message on %s: %v", msg.Subject, err)
+	}
+}
+
+// nakMessage asks JetStream to redeliver a message after the configured delay
+func (c *DataConsumer) nakMessage(msg *nats.Msg) {
+	if err := msg.NakWithDelay(c.jsNakDelay); err != nil {
+		log.Printf("Failed to nak message on %s: %v", msg.Subject, err)
+	}
+}
+
+// termMessage tells JetStream to stop redelivering a message that can never be processed
+func (c *DataConsumer) termMessage(msg *nats.Msg) {
+	if err := msg.Term(); err != nil {
+		log.Printf("Failed to terminate message on %s: %v", msg.Subject, err)
+	}
+}
@@ -29,9 +29,6 @@ func main() {
 	<-signals
 	log.Println("Received termination signal")
 
-	// Cancel the context to initiate shutdown
-	consumer.cancelFunc()
-
-	// Perform cleanup
+	// Drain in-flight messages and clean up; this also stops the background loops
 	consumer.Shutdown()
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics served on /metrics
+var (
+	messagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_messages_received_total",
+		Help: "Messages received, by source.",
+	}, []string{"source"})
+
+	readingsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_readings_received_total",
+		Help: "Decoded readings received, by sensor type.",
+	}, []string{"sensor_type"})
+
+	decodeFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_decode_failures_total",
+		Help: "Messages, or readings of a batch, that could not be decoded.",
+	})
+
+	validationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_validation_failures_total",
+		Help: "Readings rejected by validation, by sensor type and reason.",
+	}, []string{"sensor_type", "reason"})
+
+	readingsStored = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_readings_stored_total",
+		Help: "Readings written to the storage sinks, by sensor type.",
+	}, []string{"sensor_type"})
+
+	duplicatesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_duplicates_total",
+		Help: "Messages and readings dropped as duplicates.",
+	})
+
+	influxWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "consumer_influx_write_duration_seconds",
+		Help:    "Latency of InfluxDB write requests, including failed ones.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	influxWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "consumer_influx_write_errors_total",
+		Help: "InfluxDB write requests that failed.",
+	})
+
+	alertsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_alerts_sent_total",
+		Help: "Alerts handed to the email service, by sensor type.",
+	}, []string{"sensor_type"})
+
+	alertsResolved = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_alerts_resolved_total",
+		Help: "Resolve notifications handed to the email service, by sensor type.",
+	}, []string{"sensor_type"})
+
+	alertFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "consumer_alert_failures_total",
+		Help: "Alerts that could not be handed to the email service, by sensor type.",
+	}, []string{"sensor_type"})
+
+	spoolMetrics = newSpoolCollector()
+)
+
+// defaultSpoolLabel labels the spool of the default InfluxDB sink. Tenant names are never
+// empty, so it can't be mistaken for the spool of a tenant.
+const defaultSpoolLabel = ""
+
+// spoolCollector reports the backlog depth and replay progress of every open spool,
+// labelled with the tenant it belongs to, or empty for the default InfluxDB sink
+type spoolCollector struct {
+	mu     sync.Mutex
+	spools map[string]*Spool
+
+	bytesDesc    *prometheus.Desc
+	segmentsDesc *prometheus.Desc
+	spooledDesc  *prometheus.Desc
+	replayedDesc *prometheus.Desc
+	droppedDesc  *prometheus.Desc
+}
+
+// newSpoolCollector creates a collector and registers it with the default registry
+func newSpoolCollector() *spoolCollector {
+	labels := []string{"spool"}
+	c := &spoolCollector{
+		spools: make(map[string]*Spool),
+		bytesDesc: prometheus.NewDesc("consumer_spool_bytes",
+			"Bytes of line protocol waiting in the spool.", labels, nil),
+		segmentsDesc: prometheus.NewDesc("consumer_spool_segments",
+			"Segment files of the spool, including the active one.", labels, nil),
+		spooledDesc: prometheus.NewDesc("consumer_spool_points_spooled_total",
+			"Points written to the spool while InfluxDB was unavailable.", labels, nil),
+		replayedDesc: prometheus.NewDesc("consumer_spool_points_replayed_total",
+			"Spooled points replayed to InfluxDB.", labels, nil),
+		droppedDesc: prometheus.NewDesc("consumer_spool_points_dropped_total",
+			"Spooled points dropped because InfluxDB rejected them.", labels, nil),
+	}
+	prometheus.MustRegister(c)
+	return c
+}
+
+// Add reports a spool under a label
+func (c *spoolCollector) Add(label string, spool *Spool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.spools[label] = spool
+}
+
+// Describe implements prometheus.Collector
+func (c *spoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.bytesDesc
+	ch <- c.segmentsDesc
+	ch <- c.spooledDesc
+	ch <- c.replayedDesc
+	ch <- c.droppedDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *spoolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for label, spool := range c.spools {
+		stats := spool.Stats()
+		ch <- prometheus.MustNewConstMetric(c.bytesDesc, prometheus.GaugeValue, float64(stats.Bytes), label)
+		ch <- prometheus.MustNewConstMetric(c.segmentsDesc, prometheus.GaugeValue, float64(stats.Segments), label)
+		ch <- prometheus.MustNewConstMetric(c.spooledDesc, prometheus.CounterValue, float64(stats.SpooledPoints), label)
+		ch <- prometheus.MustNewConstMetric(c.replayedDesc, prometheus.CounterValue, float64(stats.ReplayedPoints), label)
+		ch <- prometheus.MustNewConstMetric(c.droppedDesc, prometheus.CounterValue, float64(stats.DroppedPoints), label)
+	}
+}
+
+// Message sources used as metric labels
+const (
+	SourceNATS = "nats"
+	SourceHTTP = "http"
+	SourceMQTT = "mqtt"
+)
+
+// metricSensorType returns the sensor type label of a reading, folding unknown
+// types together so that bad payloads can't grow the number of series
+func metricSensorType(sensorType string) string {
+	if _, ok := sensorTypeRanges[sensorType]; ok {
+		return sensorType
+	}
+	return "unknown"
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// mqttConnectTimeout bounds connecting and subscribing to the MQTT broker
+const mqttConnectTimeout = 30 * time.Second
+
+// ParseTopicTemplates parses semicolon-separated MQTT topic templates such as
+// building/{building}/{type}/{id}. They use the same placeholders as subject templates.
+func ParseTopicTemplates(patterns string) ([]*SubjectTemplate, error) {
+	var templates []*SubjectTemplate
+	for _, pattern := range strings.Split(patterns, ";") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.ContainsAny(pattern, "+#.") {
+			return nil, fmt.Errorf("topic template %q must not contain '+', '#' or '.'", pattern)
+		}
+		template, err := ParseSubjectTemplate(strings.ReplaceAll(pattern, "/", "."))
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic template %q: %w", pattern, err)
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// TopicFilter returns the MQTT topic filter matching every topic of the template
+func (t *SubjectTemplate) TopicFilter() string {
+	return t.filter("/", "+")
+}
+
+// matchTopic returns the placeholder values of the first topic template matching an MQTT topic
+func (c *DataConsumer) matchTopic(topic string) map[string]string {
+	parts := strings.Split(topic, "/")
+	for _, template := range c.mqttTopicTemplates {
+		if values, ok := template.matchTokens(parts); ok {
+			return values
+		}
+	}
+	return nil
+}
+
+// connectMQTT connects to the MQTT broker and subscribes to the topic templates. The
+// session is persistent and messages are acknowledged manually, so QoS 1 messages that
+// were not stored yet are redelivered by the broker after a reconnect.
+func (c *DataConsumer) connectMQTT() error {
+	var err error
+	c.mqttTopicTemplates, err = ParseTopicTemplates(c.mqttTopicTemplatePatterns)
+	if err != nil {
+		return err
+	}
+	c.mqttAcks = newMQTTAcks()
+	if len(c.mqttTopicTemplates) == 0 {
+		return fmt.Errorf("no MQTT topic templates configured")
+	}
+	if c.mqttQoS < 0 || c.mqttQoS > 2 {
+		return fmt.Errorf("invalid MQTT QoS %d", c.mqttQoS)
+	}
+
+	filters := make(map[string]byte, len(c.mqttTopicTemplates))
+	var topics []string
+	for _, template := range c.mqttTopicTemplates {
+		filters[template.TopicFilter()] = byte(c.mqttQoS)
+		topics = append(topics, template.TopicFilter())
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.mqttBrokerURL).
+		SetClientID(c.mqttClientID).
+		SetUsername(c.mqttUsername).
+		SetPassword(c.mqttPassword).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		// Messages of a resumed session can arrive before the subscription is restored
+		SetDefaultPublishHandler(c.MQTTMessageHandler).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("Lost connection to MQTT broker: %v", err)
+		}).
+		// Subscribe again on every (re)connect in case the broker dropped the session
+		SetOnConnectHandler(func(client mqtt.Client) {
+			token := client.SubscribeMultiple(filters, c.MQTTMessageHandler)
+			if token.WaitTimeout(mqttConnectTimeout) && token.Error() == nil {
+				log.Printf("Subscribed to MQTT topics %s", strings.Join(topics, ", "))
+			} else {
+				log.Printf("Failed to subscribe to MQTT topics: %v", token.Error())
+			}
+		})
+
+	log.Printf("Connecting to MQTT broker at %s", c.mqttBrokerURL)
+	c.mqttClient = mqtt.NewClient(opts)
+	token := c.mqttClient.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		log.Printf("MQTT broker not reachable yet, retrying in the background")
+		return nil
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	return nil
+}
+
+// mqttAcks acknowledges MQTT messages in the order the client received them, as MQTT 3.1.1
+// requires, although workers finish messages of different sensors in any order. Every
+// received message ends in Done once it was stored or dead-lettered.
+type mqttAcks struct {
+	mu   sync.Mutex
+	next uint64
+	head uint64
+	done map[uint64]mqtt.Message
+}
+
+// newMQTTAcks creates an empty acknowledgement queue
+func newMQTTAcks() *mqttAcks {
+	return &mqttAcks{done: make(map[uint64]mqtt.Message)}
+}
+
+// Receive returns the position of the next received message
+func (a *mqttAcks) Receive() uint64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seq := a.next
+	a.next++
+	return seq
+}
+
+// Done marks a message as finished and acknowledges every finished message that no earlier
+// message is still waiting for
+func (a *mqttAcks) Done(seq uint64, msg mqtt.Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.done[seq] = msg
+	for {
+		msg, ok := a.done[a.head]
+		if !ok {
+			return
+		}
+		delete(a.done, a.head)
+		a.head++
+		ackMQTT(msg)
+	}
+}
+
+// ackMQTT acknowledges a message. The client closes its acknowledgement channel when the
+// connection drops, so a late ack panics; the broker redelivers that message to the new session.
+func ackMQTT(msg mqtt.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Could not acknowledge MQTT message on %s after the connection dropped: %v", msg.Topic(), r)
+		}
+	}()
+	msg.Ack()
+}
+
+// MQTTMessageHandler decodes a message received over MQTT and hands it to the worker owning
+// its sensor. Under the block overload policy it waits for room in the worker queue, which
+// holds back further messages from the broker.
+func (c *DataConsumer) MQTTMessageHandler(_ mqtt.Client, msg mqtt.Message) {
+	topic := msg.Topic()
+	messagesReceived.WithLabelValues(SourceMQTT).Inc()
+	j := &job{mqttMsg: msg, mqttSeq: c.mqttAcks.Receive()}
+
+	readings, err := DecodeReadings(msg.Payload(), c.mqttContentType, "")
+	if err != nil {
+		log.Printf("Failed to decode MQTT message on %s: %v", topic, err)
+		decodeFailures.Inc()
+		c.deadLetterMQTTMessage(j, err)
+		return
+	}
+
+	j.readings = readings
+	key, sensorType := c.routeReadings(c.matchTopic(topic), topic, readings)
+	j.priority = c.sensorPriorities[sensorType]
+	c.workerPool.Submit(key, j)
+}
+
+// processMQTTMessage validates, stores and acknowledges an MQTT message on a worker. The
+// message is acknowledged only once its readings are stored and its rejected readings
+// dead-lettered; otherwise it is retried like a nak'd NATS message.
+func (c *DataConsumer) processMQTTMessage(j *job) {
+	topic := j.mqttMsg.Topic()
+	j.attempt++
+
+	stored, rejected, err := c.storeReadings(c.matchTopic(topic), j.readings)
+	if err != nil {
+		log.Printf("Failed to store %d readings from MQTT topic %s (attempt %d): %v", len(j.readings), topic, j.attempt, err)
+		c.retryMQTTMessage(j, err)
+		return
+	}
+	if err := c.deadLetterReadings(topic, c.mqttContentType, rejected); err != nil {
+		log.Printf("Failed to dead-letter readings from MQTT topic %s (attempt %d): %v", topic, j.attempt, err)
+		c.retryMQTTMessage(j, err)
+		return
+	}
+	c.mqttAcks.Done(j.mqttSeq, j.mqttMsg)
+
+	for _, data := range stored {
+		c.checkAlerts(data)
+	}
+}
+
+// retryMQTTMessage queues a message again after JETSTREAM_NAK_DELAY. Once it was tried
+// JETSTREAM_MAX_DELIVER times it is dead-lettered and acknowledged instead, so that it
+// doesn't hold on to one of the broker's in-flight slots.
+func (c *DataConsumer) retryMQTTMessage(j *job, cause error) {
+	topic := j.mqttMsg.Topic()
+	if j.attempt >= c.jsMaxDeliver {
+		log.Printf("Giving up on MQTT message on %s after %d attempts", topic, j.attempt)
+		c.deadLetterMQTTMessage(j, &ValidationError{
+			Reason:  ReasonUndeliverable,
+			Message: fmt.Sprintf("not stored after %d attempts: %v", j.attempt, cause),
+		})
+		return
+	}
+
+	key, _ := c.routeReadings(c.matchTopic(topic), topic, j.readings)
+	time.AfterFunc(c.jsNakDelay, func() {
+		c.workerPool.Submit(key, j)
+	})
+}
+
+// deadLetterMQTTMessage dead-letters a whole MQTT message and acknowledges it. While the
+// dead-letter subject can't be reached it tries again every JETSTREAM_NAK_DELAY.
+func (c *DataConsumer) deadLetterMQTTMessage(j *job, cause error) {
+	topic := j.mqttMsg.Topic()
+	if err := c.publishDeadLetter(topic, j.mqttMsg.Payload(), nil, cause, -1); err != nil {
+		log.Printf("Failed to dead-letter MQTT message on %s: %v", topic, err)
+		time.AfterFunc(c.jsNakDelay, func() {
+			if c.ctx.Err() == nil {
+				c.deadLetterMQTTMessage(j, cause)
+			}
+		})
+		return
+	}
+	log.Printf("Dead-lettered MQTT message on %s (%s)", topic, rejectionReason(cause))
+	c.mqttAcks.Done(j.mqttSeq, j.mqttMsg)
+}
+
+// disconnectMQTT waits briefly for in-flight MQTT work and disconnects
+func (c *DataConsumer) disconnectMQTT() {
+	c.mqttClient.Disconnect(250)
+}
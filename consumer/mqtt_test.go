@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fakeMQTTMessage records its acknowledgement in a shared list
+type fakeMQTTMessage struct {
+	id    uint16
+	acked *[]uint16
+}
+
+func (m *fakeMQTTMessage) Duplicate() bool   { return false }
+func (m *fakeMQTTMessage) Qos() byte         { return 1 }
+func (m *fakeMQTTMessage) Retained() bool    { return false }
+func (m *fakeMQTTMessage) Topic() string     { return "sensors/temperature/temp_001" }
+func (m *fakeMQTTMessage) MessageID() uint16 { return m.id }
+func (m *fakeMQTTMessage) Payload() []byte   { return nil }
+func (m *fakeMQTTMessage) Ack()              { *m.acked = append(*m.acked, m.id) }
+
+func TestMQTTAcksInReceiveOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		// done lists the received messages in the order workers finish them
+		done []int
+		// want lists the acknowledged messages after each finished one
+		want [][]uint16
+	}{
+		{
+			name: "in order",
+			done: []int{0, 1, 2},
+			want: [][]uint16{{0}, {0, 1}, {0, 1, 2}},
+		},
+		{
+			name: "later messages wait for the first",
+			done: []int{2, 1, 0},
+			want: [][]uint16{nil, nil, {0, 1, 2}},
+		},
+		{
+			name: "gap in the middle",
+			done: []int{0, 2, 1},
+			want: [][]uint16{{0}, {0}, {0, 1, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acks := newMQTTAcks()
+			var acked []uint16
+			var seqs []uint64
+			for range tt.done {
+				seqs = append(seqs, acks.Receive())
+			}
+
+			for i, n := range tt.done {
+				acks.Done(seqs[n], &fakeMQTTMessage{id: uint16(n), acked: &acked})
+				if !reflect.DeepEqual(acked, tt.want[i]) {
+					t.Errorf("after finishing message %d: got acks %v, want %v", n, acked, tt.want[i])
+				}
+			}
+		})
+	}
+}
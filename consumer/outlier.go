@@ -0,0 +1,122 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Qualities given to readings by the outlier detector
+const (
+	QualityGood    = "good"
+	QualitySuspect = "suspect"
+	QualityBad     = "bad"
+)
+
+// madScale turns a median absolute deviation into an estimate of the standard deviation
+const madScale = 1.4826
+
+// sensorWindow holds the latest values of one sensor, oldest first
+type sensorWindow struct {
+	values []float64
+	last   time.Time
+}
+
+// OutlierDetector grades readings with a Hampel filter: a reading is compared to the median
+// of the sensor's recent values, in units of their scaled median absolute deviation
+type OutlierDetector struct {
+	windowSize       int
+	minSamples       int
+	suspectThreshold float64
+	badThreshold     float64
+
+	mu      sync.Mutex
+	windows map[string]*sensorWindow
+}
+
+// NewOutlierDetector creates a detector over windows of windowSize values that starts
+// grading a sensor once minSamples of its values have been seen
+func NewOutlierDetector(windowSize, minSamples int, suspectThreshold, badThreshold float64) *OutlierDetector {
+	return &OutlierDetector{
+		windowSize:       windowSize,
+		minSamples:       minSamples,
+		suspectThreshold: suspectThreshold,
+		badThreshold:     badThreshold,
+		windows:          make(map[string]*sensorWindow),
+	}
+}
+
+// Grade tags every reading with its quality. Readings already tagged, e.g. as stuck, keep
+// their quality. Readings older than the latest one seen for their sensor are graded but
+// not added to its window, so redelivered readings don't count twice.
+func (d *OutlierDetector) Grade(batch []SensorData) []SensorData {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, data := range batch {
+		key := sensorKey(data)
+		window, ok := d.windows[key]
+		if !ok {
+			window = &sensorWindow{}
+			d.windows[key] = window
+		}
+
+		if _, tagged := data.Tags[TagQuality]; !tagged {
+			batch[i] = data.WithTag(TagQuality, d.quality(window.values, data.Value))
+		}
+
+		if data.Timestamp.After(window.last) {
+			window.last = data.Timestamp
+			window.values = append(window.values, data.Value)
+			if len(window.values) > d.windowSize {
+				window.values = window.values[len(window.values)-d.windowSize:]
+			}
+		}
+	}
+	return batch
+}
+
+// quality grades a value against the values before it
+func (d *OutlierDetector) quality(values []float64, value float64) string {
+	if len(values) == 0 || len(values) < d.minSamples {
+		return QualityGood
+	}
+
+	median := medianOf(values)
+	deviations := make([]float64, len(values))
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+	}
+	scale := madScale * medianOf(deviations)
+
+	deviation := math.Abs(value - median)
+	if scale == 0 {
+		// The window is (nearly) constant, so any change cannot be scored
+		if deviation == 0 {
+			return QualityGood
+		}
+		return QualitySuspect
+	}
+
+	score := deviation / scale
+	switch {
+	case score > d.badThreshold:
+		return QualityBad
+	case score > d.suspectThreshold:
+		return QualitySuspect
+	default:
+		return QualityGood
+	}
+}
+
+// medianOf returns the median of values without reordering them
+func medianOf(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierGrade(t *testing.T) {
+	tests := []struct {
+		name string
+		// history is graded first to fill the sensor's window
+		history []float64
+		value   float64
+		want    string
+	}{
+		{
+			name:    "too few samples",
+			history: []float64{20, 20},
+			value:   80,
+			want:    QualityGood,
+		},
+		{
+			name:    "within the spread",
+			history: []float64{20, 21, 19, 20.5, 19.5},
+			value:   21,
+			want:    QualityGood,
+		},
+		{
+			name:    "beyond the suspect threshold",
+			history: []float64{20, 21, 19, 20.5, 19.5},
+			value:   23.5,
+			want:    QualitySuspect,
+		},
+		{
+			name:    "beyond the bad threshold",
+			history: []float64{20, 21, 19, 20.5, 19.5},
+			value:   30,
+			want:    QualityBad,
+		},
+		{
+			name:    "constant window, same value",
+			history: []float64{20, 20, 20, 20, 20},
+			value:   20,
+			want:    QualityGood,
+		},
+		{
+			// The deviation of a constant window is 0, so any change can't be scored and is suspect
+			name:    "constant window, tiny change",
+			history: []float64{20, 20, 20, 20, 20},
+			value:   20.01,
+			want:    QualitySuspect,
+		},
+		{
+			name:    "constant window, large change",
+			history: []float64{20, 20, 20, 20, 20},
+			value:   80,
+			want:    QualitySuspect,
+		},
+		{
+			name:    "window keeps only the latest values",
+			history: []float64{50, 50, 50, 20, 21, 19, 20.5, 19.5},
+			value:   50,
+			want:    QualityBad,
+		},
+	}
+
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewOutlierDetector(5, 3, 3, 6)
+			reading := func(i int, value float64) SensorData {
+				return SensorData{SensorID: "temp_001", Value: value, Timestamp: start.Add(time.Duration(i) * time.Minute)}
+			}
+			for i, value := range tt.history {
+				detector.Grade([]SensorData{reading(i, value)})
+			}
+
+			graded := detector.Grade([]SensorData{reading(len(tt.history), tt.value)})
+			if quality := graded[0].Tags[TagQuality]; quality != tt.want {
+				t.Errorf("got quality %q, want %q", quality, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierGradeKeepsStuckQuality(t *testing.T) {
+	detector := NewOutlierDetector(5, 1, 3, 6)
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	detector.Grade([]SensorData{{SensorID: "temp_001", Value: 20, Timestamp: start}})
+
+	stuck := SensorData{SensorID: "temp_001", Value: 20, Timestamp: start.Add(time.Minute)}.WithTag(TagQuality, QualityStuck)
+	if quality := detector.Grade([]SensorData{stuck})[0].Tags[TagQuality]; quality != QualityStuck {
+		t.Errorf("got quality %q, want %q", quality, QualityStuck)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateSample is one reading kept for computing rates of change
+type rateSample struct {
+	timestamp time.Time
+	value     float64
+}
+
+// RateHistory keeps the recent readings of every sensor, oldest first, so that rate rules
+// can compute how much a value changed over their window
+type RateHistory struct {
+	retention time.Duration
+
+	mu      sync.Mutex
+	sensors map[string][]rateSample
+}
+
+// NewRateHistory creates a history that keeps readings for the longest window it will be
+// asked about
+func NewRateHistory(retention time.Duration) *RateHistory {
+	return &RateHistory{
+		retention: retention,
+		sensors:   make(map[string][]rateSample),
+	}
+}
+
+// Change returns how much the reading's value changed since the oldest reading of its sensor
+// within window, or since the previous reading without a window. Reports false when there
+// is no earlier reading to compare to, or when the reading is older than the latest one.
+func (h *RateHistory) Change(data SensorData, window time.Duration) (float64, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	samples := h.sensors[sensorKey(data)]
+	if len(samples) == 0 || !data.Timestamp.After(samples[len(samples)-1].timestamp) {
+		return 0, false
+	}
+	if window == 0 {
+		return data.Value - samples[len(samples)-1].value, true
+	}
+
+	since := data.Timestamp.Add(-window)
+	for _, sample := range samples {
+		if !sample.timestamp.Before(since) {
+			return data.Value - sample.value, true
+		}
+	}
+	return 0, false
+}
+
+// Add records a reading and drops the readings of its sensor that no window reaches anymore.
+// Readings older than the latest one of their sensor are ignored.
+func (h *RateHistory) Add(data SensorData) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := sensorKey(data)
+	samples := h.sensors[key]
+	if len(samples) > 0 && !data.Timestamp.After(samples[len(samples)-1].timestamp) {
+		return
+	}
+	samples = append(samples, rateSample{timestamp: data.Timestamp, value: data.Value})
+
+	// Keep the previous reading even when it is outside every window
+	since := data.Timestamp.Add(-h.retention)
+	drop := 0
+	for drop < len(samples)-2 && samples[drop].timestamp.Before(since) {
+		drop++
+	}
+	h.sensors[key] = samples[drop:]
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateHistoryChange(t *testing.T) {
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	reading := func(offset time.Duration, value float64) SensorData {
+		return SensorData{SensorID: "temp_001", Tenant: "building_a", Timestamp: start.Add(offset), Value: value}
+	}
+	// A reading every minute, rising by one each time
+	history := []SensorData{
+		reading(0, 20),
+		reading(time.Minute, 21),
+		reading(2*time.Minute, 22),
+		reading(3*time.Minute, 23),
+	}
+
+	tests := []struct {
+		name      string
+		history   []SensorData
+		retention time.Duration
+		data      SensorData
+		window    time.Duration
+		want      float64
+		wantOK    bool
+	}{
+		{
+			name:   "no earlier reading",
+			data:   reading(0, 20),
+			wantOK: false,
+		},
+		{
+			name:      "since the previous reading",
+			history:   history,
+			retention: 5 * time.Minute,
+			data:      reading(4*time.Minute, 20),
+			want:      -3,
+			wantOK:    true,
+		},
+		{
+			name:      "since the oldest reading within the window",
+			history:   history,
+			retention: 5 * time.Minute,
+			data:      reading(4*time.Minute, 30),
+			window:    2 * time.Minute,
+			want:      8,
+			wantOK:    true,
+		},
+		{
+			name:      "window reaching past the first reading",
+			history:   history,
+			retention: 10 * time.Minute,
+			data:      reading(4*time.Minute, 30),
+			window:    10 * time.Minute,
+			want:      10,
+			wantOK:    true,
+		},
+		{
+			name:      "no reading within the window",
+			history:   history,
+			retention: 5 * time.Minute,
+			data:      reading(10*time.Minute, 30),
+			window:    5 * time.Minute,
+			wantOK:    false,
+		},
+		{
+			name:      "previous reading kept beyond the retention",
+			history:   history,
+			retention: time.Minute,
+			data:      reading(time.Hour, 30),
+			want:      7,
+			wantOK:    true,
+		},
+		{
+			name:      "reading older than the latest one",
+			history:   history,
+			retention: 5 * time.Minute,
+			data:      reading(2*time.Minute+30*time.Second, 30),
+			wantOK:    false,
+		},
+		{
+			name:      "reading of another tenant",
+			history:   history,
+			retention: 5 * time.Minute,
+			data:      SensorData{SensorID: "temp_001", Tenant: "building_b", Timestamp: start.Add(4 * time.Minute), Value: 30},
+			wantOK:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates := NewRateHistory(tt.retention)
+			for _, data := range tt.history {
+				rates.Add(data)
+			}
+
+			got, ok := rates.Change(tt.data, tt.window)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRateHistoryAddIgnoresOlderReadings(t *testing.T) {
+	start := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	rates := NewRateHistory(time.Hour)
+	rates.Add(SensorData{SensorID: "temp_001", Timestamp: start.Add(time.Minute), Value: 21})
+	rates.Add(SensorData{SensorID: "temp_001", Timestamp: start, Value: 10})
+
+	got, ok := rates.Change(SensorData{SensorID: "temp_001", Timestamp: start.Add(2 * time.Minute), Value: 22}, time.Hour)
+	if !ok || got != 1 {
+		t.Errorf("got %v, %v, want 1, true", got, ok)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Tags added to readings of registered sensors
+const (
+	TagBuilding         = "building"
+	TagFloor            = "floor"
+	TagZone             = "zone"
+	TagEquipment        = "equipment"
+	TagExpectedInterval = "expectedInterval"
+)
+
+// SensorInfo is the registry entry of one sensor. Unit is the unit the sensor reports
+// when its readings carry none; ValidRange is given in the canonical unit of the sensor type.
+type SensorInfo struct {
+	Tenant           string        `yaml:"tenant"`
+	Building         string        `yaml:"building"`
+	Floor            string        `yaml:"floor"`
+	Zone             string        `yaml:"zone"`
+	Equipment        string        `yaml:"equipment"`
+	Unit             string        `yaml:"unit"`
+	ExpectedInterval time.Duration `yaml:"expectedInterval"`
+	ValidRange       *ValueRange   `yaml:"validRange"`
+	Calibration      *Calibration  `yaml:"calibration"`
+}
+
+// SensorRegistry holds the metadata of known sensors by sensor ID
+type SensorRegistry struct {
+	Sensors map[string]SensorInfo `yaml:"sensors"`
+}
+
+// LoadSensorRegistry reads a YAML or JSON sensor registry
+func LoadSensorRegistry(path string) (*SensorRegistry, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sensor registry: %w", err)
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	var registry SensorRegistry
+	if err := yaml.Unmarshal(raw, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse sensor registry %s: %w", path, err)
+	}
+	for id, info := range registry.Sensors {
+		if info.ValidRange != nil && info.ValidRange.Min > info.ValidRange.Max {
+			return nil, fmt.Errorf("sensor %q has an empty valid range [%v, %v]", id, info.ValidRange.Min, info.ValidRange.Max)
+		}
+		if info.ExpectedInterval < 0 {
+			return nil, fmt.Errorf("sensor %q has a negative expected interval", id)
+		}
+		if info.Calibration != nil {
+			if err := info.Calibration.Validate(); err != nil {
+				return nil, fmt.Errorf("sensor %q: %w", id, err)
+			}
+		}
+	}
+	return &registry, nil
+}
+
+// Lookup returns the entry of the sensor that produced a reading
+func (r *SensorRegistry) Lookup(data SensorData) (SensorInfo, bool) {
+	if r == nil {
+		return SensorInfo{}, false
+	}
+	info, ok := r.Sensors[data.SensorID]
+	if !ok || (info.Tenant != "" && info.Tenant != data.Tenant) {
+		return SensorInfo{}, false
+	}
+	return info, true
+}
+
+// Enrich adds the sensor's metadata to a reading as tags and fills in its unit
+func (info SensorInfo) Enrich(data SensorData) SensorData {
+	if data.Unit == "" {
+		data.Unit = info.Unit
+	}
+
+	tags := map[string]string{
+		TagBuilding:  info.Building,
+		TagFloor:     info.Floor,
+		TagZone:      info.Zone,
+		TagEquipment: info.Equipment,
+	}
+	if info.ExpectedInterval > 0 {
+		tags[TagExpectedInterval] = info.ExpectedInterval.String()
+	}
+	for key, value := range tags {
+		if value != "" {
+			data = data.WithTag(key, value)
+		}
+	}
+	return data
+}
+
+// CheckRange rejects readings outside the sensor's own valid range
+func (info SensorInfo) CheckRange(data SensorData) error {
+	if info.ValidRange == nil {
+		return nil
+	}
+	if data.Value < info.ValidRange.Min || data.Value > info.ValidRange.Max {
+		return &ValidationError{
+			Reason:  ReasonOutOfRange,
+			Message: fmt.Sprintf("sensor %s value %v outside its valid range [%v, %v]", data.SensorID, data.Value, info.ValidRange.Min, info.ValidRange.Max),
+		}
+	}
+	return nil
+}
+
+// sensorRegistry returns the registry currently in use, or nil
+func (c *DataConsumer) sensorRegistry() *SensorRegistry {
+	return c.registry.Load()
+}
+
+// watchSensorRegistry reloads the registry whenever its file changes. A registry that
+// fails to load is logged and the previous one stays in use.
+func (c *DataConsumer) watchSensorRegistry(modTime time.Time) {
+	ticker := time.NewTicker(c.registryReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(c.registryFile)
+			if err != nil {
+				log.Printf("Failed to check sensor registry: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			registry, err := LoadSensorRegistry(c.registryFile)
+			if err != nil {
+				log.Printf("Keeping the previous sensor registry: %v", err)
+				continue
+			}
+			c.registry.Store(registry)
+			log.Printf("Reloaded sensor registry with %d sensors", len(registry.Sensors))
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// loadSensorRegistry loads the registry and starts watching it for changes
+func (c *DataConsumer) loadSensorRegistry() error {
+	info, err := os.Stat(c.registryFile)
+	if err != nil {
+		return fmt.Errorf("failed to read sensor registry: %w", err)
+	}
+	registry, err := LoadSensorRegistry(c.registryFile)
+	if err != nil {
+		return err
+	}
+	c.registry.Store(registry)
+	log.Printf("Loaded sensor registry with %d sensors from %s", len(registry.Sensors), c.registryFile)
+
+	if c.registryReloadInterval > 0 {
+		go c.watchSensorRegistry(info.ModTime())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRegistry writes a sensor registry file and returns its path
+func writeRegistry(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sensors.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRegistryCalibration(t *testing.T) {
+	registry, err := LoadSensorRegistry(writeRegistry(t, `
+sensors:
+  offset_only:
+    calibration: {version: v1, offset: -0.5}
+  linear:
+    calibration: {version: v2, offset: 1, gain: 2}
+  curve:
+    calibration:
+      version: v3
+      points: [[20, 22], [0, 0], [10, 11]]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sensorID string
+		raw      float64
+		want     float64
+	}{
+		{"offset_only", 21.5, 21},
+		{"linear", 10, 21},
+		{"linear", -1, -1},
+		// Between the points of the curve, which were given out of order
+		{"curve", 5, 5.5},
+		{"curve", 15, 16.5},
+		{"curve", 10, 11},
+		// Beyond both ends the outermost segments are extended
+		{"curve", -10, -11},
+		{"curve", 30, 33},
+	}
+
+	for _, tt := range tests {
+		info, ok := registry.Lookup(SensorData{SensorID: tt.sensorID})
+		if !ok {
+			t.Fatalf("sensor %s is not registered", tt.sensorID)
+		}
+		data := info.Calibration.Calibrate(SensorData{SensorID: tt.sensorID, Value: tt.raw})
+		if math.Abs(data.Value-tt.want) > 1e-9 {
+			t.Errorf("%s at %v: got %v, want %v", tt.sensorID, tt.raw, data.Value, tt.want)
+		}
+		if data.RawValue == nil || *data.RawValue != tt.raw {
+			t.Errorf("%s at %v: raw value not kept", tt.sensorID, tt.raw)
+		}
+		if data.Tags[TagCalibrationVersion] != info.Calibration.Version {
+			t.Errorf("%s: got version tag %q, want %q", tt.sensorID, data.Tags[TagCalibrationVersion], info.Calibration.Version)
+		}
+	}
+}
+
+func TestRegistryRejectsInvalidCalibration(t *testing.T) {
+	tests := []struct {
+		name        string
+		calibration string
+		wantErr     string
+	}{
+		{"no version", `{offset: 1}`, "no version"},
+		{"gain of zero", `{version: v1, gain: 0}`, "gain of 0"},
+		{"points and gain", `{version: v1, gain: 2, points: [[0, 0], [1, 1]]}`, "mixes offset/gain"},
+		{"single point", `{version: v1, points: [[0, 0]]}`, "at least two points"},
+		{"repeated raw value", `{version: v1, points: [[0, 0], [0, 1]]}`, "two points"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadSensorRegistry(writeRegistry(t, "sensors:\n  temp_001:\n    calibration: "+tt.calibration+"\n"))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
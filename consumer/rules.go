@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Comparison operators of alert rules
+const (
+	OperatorAbove   = ">"
+	OperatorBelow   = "<"
+	OperatorBetween = "between"
+	OperatorOutside = "outside"
+	OperatorRise    = "rise"
+	OperatorFall    = "fall"
+)
+
+// Alert severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// AlertRule raises an alert when a reading of the selected sensors meets its condition.
+// Threshold is used by > and <, Range by between and outside (both bounds inclusive).
+// SensorIDs and Locations hold glob patterns; an empty list selects every sensor.
+// The rate operators rise and fall compare the change of a sensor's value over Window,
+// or since its previous reading without a window, to Threshold.
+// A composite rule combines the latest values of several sensors instead: All, Any and Not
+// hold conditions of single sensors or further groups, see composite.go.
+// With For set, the condition has to hold for that long before the alert fires.
+// ClearThreshold and ClearRange set where a firing alert resolves, so that values hovering
+// around the threshold don't flap; by default it resolves as soon as the condition is false.
+type AlertRule struct {
+	Name             string         `yaml:"name"`
+	SensorType       string         `yaml:"sensorType"`
+	SensorIDs        []string       `yaml:"sensorIds"`
+	Locations        []string       `yaml:"locations"`
+	Operator         string         `yaml:"operator"`
+	Threshold        float64        `yaml:"threshold"`
+	Range            *ValueRange    `yaml:"range"`
+	Window           time.Duration  `yaml:"window"`
+	ClearThreshold   *float64       `yaml:"clearThreshold"`
+	ClearRange       *ValueRange    `yaml:"clearRange"`
+	All              []*AlertRule   `yaml:"all"`
+	Any              []*AlertRule   `yaml:"any"`
+	Not              *AlertRule     `yaml:"not"`
+	JoinOn           string         `yaml:"joinOn"`
+	MaxAge           time.Duration  `yaml:"maxAge"`
+	For              time.Duration  `yaml:"for"`
+	Severity         string         `yaml:"severity"`
+	RenotifyInterval *time.Duration `yaml:"renotifyInterval"`
+}
+
+// alertRuleFile is the layout of ALERT_RULES_FILE
+type alertRuleFile struct {
+	Rules []*AlertRule `yaml:"rules"`
+}
+
+// LoadAlertRules reads a YAML or JSON list of alert rules. Unknown keys are rejected, so
+// that a misspelt field fails loudly rather than leaving a rule at its zero value.
+func LoadAlertRules(filename string) ([]*AlertRule, error) {
+	raw, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+
+	var file alertRuleFile
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse alert rules %s: %w", filename, err)
+	}
+
+	names := make(map[string]bool)
+	for i, rule := range file.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("alert rule %d: %w", i, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %q is defined twice", rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return file.Rules, nil
+}
+
+// DefaultAlertRules returns the built-in high temperature rule used without a rule file
+func DefaultAlertRules(tempThreshold float64) []*AlertRule {
+	return []*AlertRule{{
+		Name:       "high_temperature",
+		SensorType: "temperature",
+		Operator:   OperatorAbove,
+		Threshold:  tempThreshold,
+		Severity:   SeverityWarning,
+	}}
+}
+
+// Validate checks that a rule is complete and fills in its default severity
+func (r *AlertRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+	if r.IsComposite() {
+		if err := r.validateComposite(); err != nil {
+			return err
+		}
+	} else if err := r.validateCondition(); err != nil {
+		return err
+	}
+
+	if r.For < 0 {
+		return fmt.Errorf("rule %s has a negative duration", r.Name)
+	}
+	if r.RenotifyInterval != nil && *r.RenotifyInterval < 0 {
+		return fmt.Errorf("rule %s has a negative renotify interval", r.Name)
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("rule %s has unknown severity %q", r.Name, r.Severity)
+	}
+	return nil
+}
+
+// validateCondition checks the sensors and condition of a single-sensor rule
+func (r *AlertRule) validateCondition() error {
+	if r.SensorType == "" {
+		return fmt.Errorf("rule %s has no sensor type", r.Name)
+	}
+
+	switch r.Operator {
+	case OperatorAbove, OperatorBelow, OperatorRise, OperatorFall:
+		if r.ClearRange != nil {
+			return fmt.Errorf("rule %s needs a clear threshold rather than a clear range for %q", r.Name, r.Operator)
+		}
+	case OperatorBetween, OperatorOutside:
+		if r.Range == nil {
+			return fmt.Errorf("rule %s needs a range for %q", r.Name, r.Operator)
+		}
+		if r.Range.Min > r.Range.Max {
+			return fmt.Errorf("rule %s has an empty range [%v, %v]", r.Name, r.Range.Min, r.Range.Max)
+		}
+		if r.ClearThreshold != nil {
+			return fmt.Errorf("rule %s needs a clear range rather than a clear threshold for %q", r.Name, r.Operator)
+		}
+	default:
+		return fmt.Errorf("rule %s has unknown operator %q", r.Name, r.Operator)
+	}
+
+	if r.IsRate() {
+		if r.Threshold < 0 {
+			return fmt.Errorf("rule %s needs a positive change for %q", r.Name, r.Operator)
+		}
+		if r.Window < 0 {
+			return fmt.Errorf("rule %s has a negative window", r.Name)
+		}
+	} else if r.Window != 0 {
+		return fmt.Errorf("rule %s has a window, which only applies to %q and %q", r.Name, OperatorRise, OperatorFall)
+	}
+
+	// The clear condition has to lie on the safe side of the raise condition
+	switch {
+	case r.IsRate() && r.ClearThreshold != nil && *r.ClearThreshold > r.Threshold,
+		r.Operator == OperatorAbove && r.ClearThreshold != nil && *r.ClearThreshold > r.Threshold,
+		r.Operator == OperatorBelow && r.ClearThreshold != nil && *r.ClearThreshold < r.Threshold:
+		return fmt.Errorf("rule %s clears at %v, beyond its threshold %v", r.Name, *r.ClearThreshold, r.Threshold)
+	case r.Operator == OperatorOutside && r.ClearRange != nil && (r.ClearRange.Min < r.Range.Min || r.ClearRange.Max > r.Range.Max),
+		r.Operator == OperatorBetween && r.ClearRange != nil && (r.ClearRange.Min > r.Range.Min || r.ClearRange.Max < r.Range.Max):
+		return fmt.Errorf("rule %s has a clear range [%v, %v] that overlaps its alert condition", r.Name, r.ClearRange.Min, r.ClearRange.Max)
+	}
+
+	for _, pattern := range append(append([]string(nil), r.SensorIDs...), r.Locations...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("rule %s has an invalid pattern %q", r.Name, pattern)
+		}
+	}
+	return nil
+}
+
+// Selects reports whether a reading comes from a sensor the rule applies to
+func (r *AlertRule) Selects(data SensorData) bool {
+	return data.SensorType == r.SensorType &&
+		matchesAny(r.SensorIDs, data.SensorID) &&
+		matchesAny(r.Locations, data.Location)
+}
+
+// IsRate reports whether the rule looks at the change of values rather than at the values
+func (r *AlertRule) IsRate() bool {
+	return r.Operator == OperatorRise || r.Operator == OperatorFall
+}
+
+// Triggered reports whether a value, or the change of values for rate rules, meets the
+// rule's condition
+func (r *AlertRule) Triggered(value float64) bool {
+	switch r.Operator {
+	case OperatorRise:
+		return value > r.Threshold
+	case OperatorFall:
+		return -value > r.Threshold
+	case OperatorAbove:
+		return value > r.Threshold
+	case OperatorBelow:
+		return value < r.Threshold
+	case OperatorBetween:
+		return value >= r.Range.Min && value <= r.Range.Max
+	case OperatorOutside:
+		return value < r.Range.Min || value > r.Range.Max
+	}
+	return false
+}
+
+// Resolved reports whether a value, or the change of values for rate rules, clears a
+// firing alert of the rule
+func (r *AlertRule) Resolved(value float64) bool {
+	switch r.Operator {
+	case OperatorRise:
+		if r.ClearThreshold != nil {
+			return value <= *r.ClearThreshold
+		}
+		return value <= r.Threshold
+	case OperatorFall:
+		if r.ClearThreshold != nil {
+			return -value <= *r.ClearThreshold
+		}
+		return -value <= r.Threshold
+	case OperatorAbove:
+		if r.ClearThreshold != nil {
+			return value <= *r.ClearThreshold
+		}
+		return value <= r.Threshold
+	case OperatorBelow:
+		if r.ClearThreshold != nil {
+			return value >= *r.ClearThreshold
+		}
+		return value >= r.Threshold
+	case OperatorBetween:
+		clear := r.Range
+		if r.ClearRange != nil {
+			clear = r.ClearRange
+		}
+		return value < clear.Min || value > clear.Max
+	case OperatorOutside:
+		clear := r.Range
+		if r.ClearRange != nil {
+			clear = r.ClearRange
+		}
+		return value >= clear.Min && value <= clear.Max
+	}
+	return true
+}
+
+// Condition describes the rule's condition, e.g. "> 30 for 10m0s", "outside [20, 60]",
+// "rise > 3 within 5m0s" or "all of (humidity > 70, temperature > 25) per location"
+func (r *AlertRule) Condition() string {
+	condition := fmt.Sprintf("%s %v", r.Operator, r.Threshold)
+	switch {
+	case r.IsComposite():
+		condition = r.describeGroup()
+		if r.JoinOn != "" {
+			condition += " per " + r.JoinOn
+		}
+	case r.Range != nil && (r.Operator == OperatorBetween || r.Operator == OperatorOutside):
+		condition = fmt.Sprintf("%s [%v, %v]", r.Operator, r.Range.Min, r.Range.Max)
+	case r.IsRate() && r.Window > 0:
+		condition = fmt.Sprintf("%s > %v within %s", r.Operator, r.Threshold, r.Window)
+	case r.IsRate():
+		condition = fmt.Sprintf("%s > %v between readings", r.Operator, r.Threshold)
+	}
+	if r.For > 0 {
+		condition += " for " + r.For.String()
+	}
+	return condition
+}
+
+// matchesAny reports whether value matches one of the glob patterns, or whether there are none
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// loadAlertRules loads ALERT_RULES_FILE, or the built-in temperature rule without one
+func (c *DataConsumer) loadAlertRules() error {
+	if c.alertRulesFile == "" {
+		c.alertRules = DefaultAlertRules(c.tempAlertThreshold)
+		return nil
+	}
+
+	rules, err := LoadAlertRules(c.alertRulesFile)
+	if err != nil {
+		return err
+	}
+	c.alertRules = rules
+
+	// Rate rules need the recent readings of their sensors, composite rules the latest ones
+	names := make([]string, len(rules))
+	var retention time.Duration
+	rates := false
+	for i, rule := range rules {
+		names[i] = rule.Name
+		if rule.IsRate() {
+			rates = true
+			if rule.Window > retention {
+				retention = rule.Window
+			}
+		}
+		if rule.IsComposite() && c.latest == nil {
+			c.latest = NewLatestReadings()
+		}
+	}
+	if rates {
+		c.rates = NewRateHistory(retention)
+	}
+	log.Printf("Loaded %d alert rules from %s: %s", len(rules), c.alertRulesFile, strings.Join(names, ", "))
+	return nil
+}
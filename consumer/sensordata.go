@@ -10,5 +10,24 @@ type SensorData struct {
 	SensorID   string    `json:"sensorId"`
 	Location   string    `json:"location"`
 	Value      float64   `json:"value"`
+	Unit       string    `json:"unit"`
 	Timestamp  time.Time `json:"timestamp"`
+	Tenant     string    `json:"tenant,omitempty"`
+
+	// Tags holds additional InfluxDB tags derived during ingestion
+	Tags map[string]string `json:"-"`
+
+	// RawValue holds the value before calibration, if the reading was calibrated
+	RawValue *float64 `json:"-"`
+}
+
+// WithTag returns a copy of the reading with an additional tag
+func (d SensorData) WithTag(key, value string) SensorData {
+	tags := make(map[string]string, len(d.Tags)+1)
+	for k, v := range d.Tags {
+		tags[k] = v
+	}
+	tags[key] = value
+	d.Tags = tags
+	return d
 }
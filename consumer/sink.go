@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Sink names accepted in SINKS
+const (
+	SinkInflux = "influx"
+	SinkFile   = "file"
+	SinkSQLite = "sqlite"
+)
+
+// Sink is a storage backend for validated sensor readings. Write returns once the
+// batch is durable in the sink, so the message can be acknowledged afterwards.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, batch []SensorData) error
+	Close() error
+}
+
+// HealthChecker is implemented by sinks that can tell whether their backend is usable
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// FanoutSink writes every batch to several sinks at once
+type FanoutSink struct {
+	sinks []Sink
+}
+
+// NewFanoutSink creates a sink that mirrors writes to all given sinks
+func NewFanoutSink(sinks ...Sink) *FanoutSink {
+	return &FanoutSink{sinks: sinks}
+}
+
+// Name returns the names of the underlying sinks
+func (f *FanoutSink) Name() string {
+	names := make([]string, len(f.sinks))
+	for i, sink := range f.sinks {
+		names[i] = sink.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+// Write writes the batch to all sinks concurrently. The write fails if any sink fails,
+// and the redelivered batch is written to every sink again: InfluxDB and SQLite overwrite
+// the same readings, but the file sink appends them a second time.
+func (f *FanoutSink) Write(ctx context.Context, batch []SensorData) error {
+	errs := make([]error, len(f.sinks))
+	var wg sync.WaitGroup
+	for i, sink := range f.sinks {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			if err := sink.Write(ctx, batch); err != nil {
+				errs[i] = fmt.Errorf("%s sink: %w", sink.Name(), err)
+			}
+		}(i, sink)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Check checks every sink that supports health checks
+func (f *FanoutSink) Check(ctx context.Context) error {
+	var errs []error
+	for _, sink := range f.sinks {
+		if checker, ok := sink.(HealthChecker); ok {
+			if err := checker.Check(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s sink: %w", sink.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all sinks
+func (f *FanoutSink) Close() error {
+	var errs []error
+	for _, sink := range f.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s sink: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ParseSinkNames parses a comma-separated list of sink names such as "influx,file"
+func ParseSinkNames(list string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		switch name {
+		case SinkInflux, SinkFile, SinkSQLite:
+		default:
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("no storage sink configured")
+	}
+	return names, nil
+}
+
+// newSink creates the configured storage sinks, fanning out when there are several
+func (c *DataConsumer) newSink() (Sink, error) {
+	names, err := ParseSinkNames(c.sinkNames)
+	if err != nil {
+		return nil, err
+	}
+
+	var sinks []Sink
+	for _, name := range names {
+		var sink Sink
+		switch name {
+		case SinkInflux:
+			sink, err = c.newInfluxSink()
+		case SinkFile:
+			sink, err = NewFileSink(c.fileSinkDir, int64(c.fileSinkMaxBytes), c.fileSinkMaxFiles)
+		case SinkSQLite:
+			sink, err = NewSQLiteSink(c.sqliteSinkPath)
+		}
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to create %s sink: %w", name, err)
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return NewFanoutSink(sinks...), nil
+}
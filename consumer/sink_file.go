@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File names used by the file sink
+const (
+	fileSinkCurrent = "readings.jsonl"
+	fileSinkPrefix  = "readings-"
+	fileSinkSuffix  = ".jsonl"
+)
+
+// fileSinkRecord is the JSON line written for a reading; unlike SensorData it keeps the
+// tags and the raw value
+type fileSinkRecord struct {
+	SensorData
+	Tags     map[string]string `json:"tags,omitempty"`
+	RawValue *float64          `json:"rawValue,omitempty"`
+}
+
+// FileSink appends readings as JSON lines to a file that is rotated once it grows past
+// maxBytes. At most maxFiles rotated files are kept. Delivery is at-least-once: a batch
+// that is redelivered, e.g. after another sink failed, is appended again, so readers
+// have to tolerate duplicate lines.
+type FileSink struct {
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens, or creates, the current JSON-lines file in dir
+func NewFileSink(dir string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create file sink directory: %w", err)
+	}
+
+	s := &FileSink{dir: dir, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Name returns the sink name
+func (s *FileSink) Name() string {
+	return SinkFile
+}
+
+// Write appends the batch to the current file and syncs it to disk
+func (s *FileSink) Write(ctx context.Context, batch []SensorData) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, data := range batch {
+		if err := enc.Encode(fileSinkRecord{SensorData: data, Tags: data.Tags, RawValue: data.RawValue}); err != nil {
+			return fmt.Errorf("failed to encode reading: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return errors.New("file sink is closed")
+	}
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(buf.Len()) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write readings file: %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync readings file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the current file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// open opens the current file for appending
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, fileSinkCurrent), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open readings file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat readings file: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file with a timestamp, opens a new one and removes the
+// oldest rotated files beyond maxFiles
+func (s *FileSink) rotate() error {
+	// The open file keeps receiving writes until the rename succeeded
+	rotated := fileSinkPrefix + time.Now().UTC().Format("20060102T150405.000000000") + fileSinkSuffix
+	if err := os.Rename(filepath.Join(s.dir, fileSinkCurrent), filepath.Join(s.dir, rotated)); err != nil {
+		return fmt.Errorf("failed to rotate readings file: %w", err)
+	}
+	s.file.Close()
+	s.file = nil
+
+	if err := s.open(); err != nil {
+		return err
+	}
+	return s.prune()
+}
+
+// prune removes the oldest rotated files so that at most maxFiles remain
+func (s *FileSink) prune() error {
+	if s.maxFiles <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read file sink directory: %w", err)
+	}
+	var rotated []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, fileSinkPrefix) && strings.HasSuffix(name, fileSinkSuffix) {
+			rotated = append(rotated, name)
+		}
+	}
+
+	// Timestamps in the names sort chronologically
+	sort.Strings(rotated)
+	for len(rotated) > s.maxFiles {
+		if err := os.Remove(filepath.Join(s.dir, rotated[0])); err != nil {
+			return fmt.Errorf("failed to remove rotated readings file: %w", err)
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
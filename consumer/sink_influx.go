@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+// InfluxSink stores readings in an InfluxDB bucket. While InfluxDB is unreachable the
+// points are appended to an optional on-disk spool and replayed later.
+type InfluxSink struct {
+	client          influxdb2.Client
+	writeAPI        api.WriteAPIBlocking
+	org             string
+	bucket          string
+	spool           *Spool
+	replayBatchSize int
+}
+
+// NewInfluxSink creates a sink writing to the given InfluxDB bucket. spool may be nil.
+func NewInfluxSink(url, token, org, bucket string, spool *Spool, replayBatchSize int) *InfluxSink {
+	client := influxdb2.NewClient(url, token)
+	return &InfluxSink{
+		client:          client,
+		writeAPI:        client.WriteAPIBlocking(org, bucket),
+		org:             org,
+		bucket:          bucket,
+		spool:           spool,
+		replayBatchSize: replayBatchSize,
+	}
+}
+
+// newInfluxSink connects to InfluxDB and opens the spool from the consumer configuration.
+// With a tenant routing table, readings of routed tenants go to their own buckets.
+func (c *DataConsumer) newInfluxSink() (Sink, error) {
+	log.Printf("Connecting to InfluxDB at %s", c.influxURL)
+
+	// Open the on-disk spool that holds points while InfluxDB is unreachable
+	var spool *Spool
+	if c.spoolDir != "" {
+		var err error
+		spool, err = OpenSpool(c.spoolDir, c.spoolSegmentSize, c.spoolMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		if !spool.Empty() {
+			log.Printf("Found %d spooled bytes from a previous run", spool.Stats().Bytes)
+		}
+		spoolMetrics.Add(defaultSpoolLabel, spool)
+	}
+
+	sink := NewInfluxSink(c.influxURL, c.influxToken, c.influxOrg, c.influxBucket, spool, c.spoolReplayBatchSize)
+	if spool != nil {
+		go sink.RunReplay(c.ctx, c.spoolReplayInterval)
+	}
+	c.influxSink = sink
+
+	if c.tenantRoutes != nil {
+		return c.newTenantInfluxSink(sink)
+	}
+	return sink, nil
+}
+
+// newPoint converts sensor data into an InfluxDB point
+func newPoint(data SensorData) *write.Point {
+	p := influxdb2.NewPointWithMeasurement(data.SensorType).
+		AddTag("sensorId", data.SensorID).
+		AddTag("location", data.Location).
+		AddTag("unit", data.Unit).
+		AddField("value", data.Value).
+		SetTime(data.Timestamp)
+
+	for key, value := range data.Tags {
+		p.AddTag(key, value)
+	}
+	if data.RawValue != nil {
+		p.AddField("rawValue", *data.RawValue)
+	}
+	return p
+}
+
+// Name returns the sink name
+func (s *InfluxSink) Name() string {
+	return SinkInflux
+}
+
+// Write stores several readings in InfluxDB with a single write request. While
+// InfluxDB is unreachable, or older points are still waiting to be replayed, the points
+// are appended to the on-disk spool instead.
+func (s *InfluxSink) Write(ctx context.Context, batch []SensorData) error {
+	points := make([]*write.Point, len(batch))
+	for i, data := range batch {
+		points[i] = newPoint(data)
+	}
+
+	// Keep points in order behind an existing backlog
+	if s.spool != nil && !s.spool.Empty() {
+		return s.spoolPoints(points)
+	}
+
+	// Write to InfluxDB
+	err := observeWrite(func() error { return s.writeAPI.WritePoint(ctx, points...) })
+	if err != nil {
+		if s.spool == nil || isPermanentWriteError(err) {
+			return fmt.Errorf("failed to write %d points to InfluxDB: %w", len(points), err)
+		}
+		log.Printf("InfluxDB write failed, spooling %d points: %v", len(points), err)
+		return s.spoolPoints(points)
+	}
+	return nil
+}
+
+// Check pings InfluxDB and makes sure the sink's bucket exists
+func (s *InfluxSink) Check(ctx context.Context) error {
+	healthy, err := s.client.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("InfluxDB unreachable: %w", err)
+	}
+	if !healthy {
+		return fmt.Errorf("InfluxDB is not healthy")
+	}
+	if _, err := s.client.BucketsAPI().FindBucketByName(ctx, s.bucket); err != nil {
+		return fmt.Errorf("bucket %s: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// observeWrite runs an InfluxDB write request, recording its latency and outcome
+func observeWrite(send func() error) error {
+	start := time.Now()
+	err := send()
+	influxWriteDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		influxWriteErrors.Inc()
+	}
+	return err
+}
+
+// Close flushes pending writes and closes the spool and the client
+func (s *InfluxSink) Close() error {
+	s.writeAPI.Flush(context.Background())
+
+	if s.spool != nil {
+		s.spool.Close()
+	}
+	s.client.Close()
+	return nil
+}
+
+// spoolPoints appends points to the on-disk spool for later replay
+func (s *InfluxSink) spoolPoints(points []*write.Point) error {
+	lines := make([]string, len(points))
+	for i, p := range points {
+		lines[i] = write.PointToLineProtocol(p, time.Nanosecond)
+	}
+
+	if err := s.spool.Append(lines); err != nil {
+		return fmt.Errorf("failed to spool %d points: %w", len(points), err)
+	}
+	log.Printf("Spooled %d points to disk while InfluxDB is unavailable", len(points))
+	return nil
+}
+
+// RunReplay periodically replays spooled points once InfluxDB is reachable again
+func (s *InfluxSink) RunReplay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.replaySpool(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// replaySpool writes the spooled backlog to InfluxDB in order
+func (s *InfluxSink) replaySpool(ctx context.Context) {
+	if s.spool.Empty() {
+		return
+	}
+
+	stats := s.spool.Stats()
+	log.Printf("Spool backlog: %d segments, %d bytes, %d points replayed of %d spooled",
+		stats.Segments, stats.Bytes, stats.ReplayedPoints, stats.SpooledPoints)
+
+	// Only start replaying once InfluxDB answers again
+	pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	healthy, err := s.client.Ping(pingCtx)
+	cancel()
+	if err != nil || !healthy {
+		log.Printf("InfluxDB still unavailable, keeping %d spooled bytes", stats.Bytes)
+		return
+	}
+
+	err = s.spool.Replay(s.replayBatchSize, func(lines []string) error {
+		err := observeWrite(func() error { return s.writeAPI.WriteRecord(ctx, lines...) })
+		if err != nil && isPermanentWriteError(err) {
+			// A rejected batch would block the replay forever
+			log.Printf("InfluxDB rejected %d spooled points, dropping them: %v", len(lines), err)
+			s.spool.droppedPoints.Add(uint64(len(lines)))
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		log.Printf("Spool replay interrupted: %v", err)
+		return
+	}
+
+	stats = s.spool.Stats()
+	log.Printf("Spool replay complete: %d points replayed, %d dropped", stats.ReplayedPoints, stats.DroppedPoints)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema creates the readings table. A reading is identified by its tenant, sensor and
+// timestamp, so a redelivered batch replaces the rows it already wrote.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS readings (
+	tenant      TEXT    NOT NULL DEFAULT '',
+	sensor_type TEXT    NOT NULL,
+	sensor_id   TEXT    NOT NULL,
+	location    TEXT    NOT NULL,
+	value       REAL    NOT NULL,
+	raw_value   REAL,
+	unit        TEXT    NOT NULL,
+	timestamp   INTEGER NOT NULL,
+	tags        TEXT,
+	PRIMARY KEY (tenant, sensor_type, sensor_id, timestamp)
+);
+CREATE INDEX IF NOT EXISTS readings_timestamp ON readings (timestamp);
+`
+
+// sqliteInsert stores a reading, replacing an earlier copy of it
+const sqliteInsert = `INSERT OR REPLACE INTO readings
+	(tenant, sensor_type, sensor_id, location, value, raw_value, unit, timestamp, tags)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// SQLiteSink stores readings in an embedded SQLite database, with timestamps in
+// nanoseconds since the Unix epoch and tags as a JSON object
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink opens, or creates, the SQLite database at path
+func NewSQLiteSink(path string) (*SQLiteSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create SQLite directory: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %w", err)
+	}
+	// SQLite allows a single writer at a time
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create SQLite schema: %w", err)
+	}
+	return &SQLiteSink{db: db}, nil
+}
+
+// Name returns the sink name
+func (s *SQLiteSink) Name() string {
+	return SinkSQLite
+}
+
+// Write inserts the batch in a single transaction
+func (s *SQLiteSink) Write(ctx context.Context, batch []SensorData) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin SQLite transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, sqliteInsert)
+	if err != nil {
+		return fmt.Errorf("failed to prepare SQLite insert: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, data := range batch {
+		var tags sql.NullString
+		if len(data.Tags) > 0 {
+			encoded, err := json.Marshal(data.Tags)
+			if err != nil {
+				return fmt.Errorf("failed to encode tags: %w", err)
+			}
+			tags = sql.NullString{String: string(encoded), Valid: true}
+		}
+
+		_, err := stmt.ExecContext(ctx, data.Tenant, data.SensorType, data.SensorID, data.Location,
+			data.Value, data.RawValue, data.Unit, data.Timestamp.UnixNano(), tags)
+		if err != nil {
+			return fmt.Errorf("failed to insert reading: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit SQLite transaction: %w", err)
+	}
+	return nil
+}
+
+// Check makes sure the database can still be used
+func (s *SQLiteSink) Check(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFanoutRedeliversToAllSinks(t *testing.T) {
+	influx := &recordingSink{name: "influx"}
+	file := &recordingSink{name: "file", err: errors.New("disk full")}
+	c := &DataConsumer{
+		ctx:        context.Background(),
+		sink:       NewFanoutSink(influx, file),
+		dedupCache: NewMemoryDedupCache(time.Hour, 100),
+		flatlines:  NewFlatlineDetector(nil),
+	}
+	reading := SensorData{SensorType: "temperature", SensorID: "temp_001", Value: 21, Timestamp: time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)}
+
+	// The file sink fails, so the message is nak'd although InfluxDB stored the reading
+	if _, err := c.storeValid([]SensorData{reading}); err == nil {
+		t.Fatal("got no error with a failing sink")
+	}
+	if len(influx.batches) != 1 || len(file.batches) != 0 {
+		t.Fatalf("got %d influx and %d file batches, want 1 and 0", len(influx.batches), len(file.batches))
+	}
+
+	// The redelivery is not taken for a duplicate and goes to every sink again
+	file.err = nil
+	stored, err := c.storeValid([]SensorData{reading})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("stored %d readings on redelivery, want 1", len(stored))
+	}
+	if len(influx.batches) != 2 || len(file.batches) != 1 {
+		t.Errorf("got %d influx and %d file batches, want 2 and 1", len(influx.batches), len(file.batches))
+	}
+
+	// Once stored everywhere, a further redelivery is a duplicate
+	if stored, _ := c.storeValid([]SensorData{reading}); len(stored) != 0 {
+		t.Errorf("stored %d readings of a duplicate, want none", len(stored))
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/influxdb-client-go/v2/api/http"
+)
+
+// segmentExtension is the file extension of spool segments
+const segmentExtension = ".lp"
+
+// ErrSpoolFull is returned when appending would exceed the spool's size cap
+var ErrSpoolFull = errors.New("spool is full")
+
+// Spool is an on-disk, append-only log of line protocol records that could not be
+// written to InfluxDB. Records are kept in numbered segment files and replayed in order.
+type Spool struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	maxBytes    int64
+
+	// Segments in replay order; the last one is the active segment while it is open
+	segments     []int64
+	segmentSizes map[int64]int64
+	totalBytes   int64
+	active       *os.File
+	activeID     int64
+	nextID       int64
+
+	// Number of lines of the oldest segment that have already been replayed
+	replayedLines int
+
+	// Counters for monitoring the backlog
+	spooledPoints  atomic.Uint64
+	replayedPoints atomic.Uint64
+	droppedPoints  atomic.Uint64
+}
+
+// SpoolStats describes the backlog of a spool
+type SpoolStats struct {
+	Segments       int
+	Bytes          int64
+	SpooledPoints  uint64
+	ReplayedPoints uint64
+	DroppedPoints  uint64
+}
+
+// OpenSpool opens the spool directory, picking up segments left over from a previous run
+func OpenSpool(dir string, segmentSize, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	s := &Spool{
+		dir:          dir,
+		segmentSize:  segmentSize,
+		maxBytes:     maxBytes,
+		segmentSizes: make(map[int64]int64),
+		nextID:       1,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExtension) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(name, segmentExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat spool segment %s: %w", name, err)
+		}
+
+		s.segments = append(s.segments, id)
+		s.segmentSizes[id] = info.Size()
+		s.totalBytes += info.Size()
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	return s, nil
+}
+
+// segmentPath returns the file name of a segment
+func (s *Spool) segmentPath(id int64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExtension))
+}
+
+// Empty reports whether there is nothing left to replay
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) == 0
+}
+
+// Stats returns the current backlog depth and replay progress
+func (s *Spool) Stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SpoolStats{
+		Segments:       len(s.segments),
+		Bytes:          s.totalBytes,
+		SpooledPoints:  s.spooledPoints.Load(),
+		ReplayedPoints: s.replayedPoints.Load(),
+		DroppedPoints:  s.droppedPoints.Load(),
+	}
+}
+
+// Append durably adds line protocol records to the end of the spool
+func (s *Spool) Append(lines []string) error {
+	var data []byte
+	count := 0
+	for _, line := range lines {
+		line = strings.TrimRight(line, "\n")
+		if line == "" {
+			continue
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+		count++
+	}
+	if count == 0 {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxBytes > 0 && s.totalBytes+int64(len(data)) > s.maxBytes {
+		return ErrSpoolFull
+	}
+
+	// Start a new segment when there is none or the active one is full
+	if s.active == nil || s.segmentSizes[s.activeID] >= s.segmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.active.Write(data); err != nil {
+		return fmt.Errorf("failed to append to spool: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool: %w", err)
+	}
+
+	s.segmentSizes[s.activeID] += int64(len(data))
+	s.totalBytes += int64(len(data))
+	s.spooledPoints.Add(uint64(count))
+	return nil
+}
+
+// rotate closes the active segment and opens a new one. Callers must hold the lock.
+func (s *Spool) rotate() error {
+	s.seal()
+
+	id := s.nextID
+	file, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+
+	s.nextID++
+	s.active = file
+	s.activeID = id
+	s.segments = append(s.segments, id)
+	s.segmentSizes[id] = 0
+	return nil
+}
+
+// seal closes the active segment so that it can be replayed. Callers must hold the lock.
+func (s *Spool) seal() {
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+}
+
+// Replay writes the spooled records in order, batchSize lines at a time, deleting each
+// segment once it has been written. It stops at the first error returned by write,
+// resuming from the same line on the next call.
+func (s *Spool) Replay(batchSize int, write func(lines []string) error) error {
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		id := s.segments[0]
+		if s.active != nil && id == s.activeID {
+			s.seal()
+		}
+		skip := s.replayedLines
+		s.mu.Unlock()
+
+		replayed, err := s.replaySegment(id, skip, batchSize, write)
+
+		s.mu.Lock()
+		s.replayedLines = skip + replayed
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if removeErr := os.Remove(s.segmentPath(id)); removeErr != nil && !os.IsNotExist(removeErr) {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to remove replayed spool segment: %w", removeErr)
+		}
+		s.totalBytes -= s.segmentSizes[id]
+		delete(s.segmentSizes, id)
+		s.segments = s.segments[1:]
+		s.replayedLines = 0
+		s.mu.Unlock()
+	}
+}
+
+// replaySegment writes the lines of one segment after the first skip lines and
+// returns how many lines it wrote
+func (s *Spool) replaySegment(id int64, skip, batchSize int, write func(lines []string) error) (int, error) {
+	file, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return 0, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	replayed := 0
+	batch := make([]string, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := write(batch); err != nil {
+			return err
+		}
+		replayed += len(batch)
+		s.replayedPoints.Add(uint64(len(batch)))
+		batch = batch[:0]
+		return nil
+	}
+
+	for line := 0; scanner.Scan(); line++ {
+		if line < skip {
+			continue
+		}
+		batch = append(batch, scanner.Text())
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return replayed, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return replayed, fmt.Errorf("failed to read spool segment: %w", err)
+	}
+	return replayed, flush()
+}
+
+// Close closes the active segment
+func (s *Spool) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seal()
+}
+
+// isPermanentWriteError reports whether InfluxDB rejected a write in a way that retrying cannot fix
+func isPermanentWriteError(err error) bool {
+	var httpErr *http.Error
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode >= 400 && httpErr.StatusCode < 500 && httpErr.StatusCode != 429
+	}
+	return false
+}
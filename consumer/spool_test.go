@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSpoolReplay(t *testing.T) {
+	tests := []struct {
+		name        string
+		segmentSize int64
+		appends     int
+		// crash reopens the spool without closing it before replaying
+		crash bool
+		// failAfter makes the first replay fail after that many successful writes, or never when negative
+		failAfter int
+	}{
+		{name: "single segment", segmentSize: 1 << 20, appends: 3, failAfter: -1},
+		{name: "many segments", segmentSize: 1, appends: 5, failAfter: -1},
+		{name: "crash before replay", segmentSize: 16, appends: 5, crash: true, failAfter: -1},
+		{name: "write fails mid segment", segmentSize: 1 << 20, appends: 4, failAfter: 2},
+		{name: "write fails after a segment", segmentSize: 1, appends: 4, failAfter: 3},
+		{name: "crash and write fails", segmentSize: 16, appends: 6, crash: true, failAfter: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool, err := OpenSpool(dir, tt.segmentSize, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want []string
+			for i := 0; i < tt.appends; i++ {
+				lines := []string{fmt.Sprintf("temperature,sensor=t%d value=%d", i, i), fmt.Sprintf("humidity,sensor=h%d value=%d\n", i, i)}
+				if err := spool.Append(lines); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, fmt.Sprintf("temperature,sensor=t%d value=%d", i, i), fmt.Sprintf("humidity,sensor=h%d value=%d", i, i))
+			}
+			if tt.crash {
+				spool, err = OpenSpool(dir, tt.segmentSize, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var got []string
+			writes := 0
+			write := func(lines []string) error {
+				if writes == tt.failAfter {
+					writes++
+					return errors.New("influxdb unavailable")
+				}
+				writes++
+				got = append(got, lines...)
+				return nil
+			}
+			err = spool.Replay(1, write)
+			if tt.failAfter >= 0 {
+				if err == nil {
+					t.Fatal("expected the failing write to stop the replay")
+				}
+				if spool.Empty() {
+					t.Fatal("spool is empty after a failed replay")
+				}
+				err = spool.Replay(1, write)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("replayed %q, want %q", got, want)
+			}
+			if stats := spool.Stats(); stats.Segments != 0 || stats.Bytes != 0 {
+				t.Errorf("got %d segments and %d bytes left, want none", stats.Segments, stats.Bytes)
+			}
+		})
+	}
+}
+
+func TestSpoolByteCap(t *testing.T) {
+	line := "temperature,sensor=t1 value=1"
+	size := int64(len(line) + 1)
+
+	tests := []struct {
+		name     string
+		maxBytes int64
+		appends  int
+		wantErrs int
+	}{
+		{name: "no cap", maxBytes: 0, appends: 5},
+		{name: "fits exactly", maxBytes: 3 * size, appends: 3},
+		{name: "over the cap", maxBytes: 3 * size, appends: 5, wantErrs: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spool, err := OpenSpool(t.TempDir(), 2*size, tt.maxBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer spool.Close()
+
+			errs := 0
+			for i := 0; i < tt.appends; i++ {
+				if err := spool.Append([]string{line}); errors.Is(err, ErrSpoolFull) {
+					errs++
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if errs != tt.wantErrs {
+				t.Errorf("got %d full errors, want %d", errs, tt.wantErrs)
+			}
+
+			// Replaying frees the space again
+			if err := spool.Replay(10, func([]string) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+			if err := spool.Append([]string{line}); err != nil {
+				t.Errorf("append after replay: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sensor statuses published on <status prefix>.<status>
+const (
+	StatusOffline = "offline"
+	StatusOnline  = "online"
+)
+
+// StatusEvent announces that a sensor stopped or resumed publishing
+type StatusEvent struct {
+	Status           string    `json:"status"`
+	SensorID         string    `json:"sensorId"`
+	SensorType       string    `json:"sensorType"`
+	Location         string    `json:"location"`
+	Tenant           string    `json:"tenant,omitempty"`
+	LastSeen         time.Time `json:"lastSeen"`
+	ExpectedInterval string    `json:"expectedInterval"`
+	Time             time.Time `json:"time"`
+}
+
+// sensorActivity is the last-seen state of one sensor
+type sensorActivity struct {
+	data     SensorData
+	lastSeen time.Time
+	offline  bool
+}
+
+// StalenessTracker remembers when each sensor was last seen
+type StalenessTracker struct {
+	mu      sync.Mutex
+	sensors map[string]*sensorActivity
+}
+
+// NewStalenessTracker creates an empty tracker
+func NewStalenessTracker() *StalenessTracker {
+	return &StalenessTracker{sensors: make(map[string]*sensorActivity)}
+}
+
+// sensorKey identifies a sensor across tenants
+func sensorKey(data SensorData) string {
+	if data.Tenant == "" {
+		return data.SensorID
+	}
+	return data.Tenant + "|" + data.SensorID
+}
+
+// Seen records readings received at now and returns the sensors that were offline until now
+func (t *StalenessTracker) Seen(batch []SensorData, now time.Time) []*sensorActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var recovered []*sensorActivity
+	for _, data := range batch {
+		key := sensorKey(data)
+		activity, ok := t.sensors[key]
+		if !ok {
+			activity = &sensorActivity{}
+			t.sensors[key] = activity
+		}
+		if activity.offline {
+			recovered = append(recovered, &sensorActivity{data: activity.data, lastSeen: activity.lastSeen})
+			activity.offline = false
+		}
+		activity.data = data
+		activity.lastSeen = now
+	}
+	return recovered
+}
+
+// Expired marks sensors silent for longer than their timeout as offline and returns them
+func (t *StalenessTracker) Expired(now time.Time, timeout func(SensorData) time.Duration) []*sensorActivity {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var expired []*sensorActivity
+	for _, activity := range t.sensors {
+		if activity.offline {
+			continue
+		}
+		if limit := timeout(activity.data); limit > 0 && now.Sub(activity.lastSeen) > limit {
+			activity.offline = true
+			expired = append(expired, &sensorActivity{data: activity.data, lastSeen: activity.lastSeen})
+		}
+	}
+	return expired
+}
+
+// expectedInterval returns how often a sensor is expected to publish
+func (c *DataConsumer) expectedInterval(data SensorData) time.Duration {
+	if info, ok := c.sensorRegistry().Lookup(data); ok && info.ExpectedInterval > 0 {
+		return info.ExpectedInterval
+	}
+	return c.staleDefaultInterval
+}
+
+// staleTimeout returns how long a sensor may stay silent before it is considered offline
+func (c *DataConsumer) staleTimeout(data SensorData) time.Duration {
+	return time.Duration(float64(c.expectedInterval(data)) * c.staleIntervalFactor)
+}
+
+// isStatusSubject reports whether a subject carries sensor status events
+func (c *DataConsumer) isStatusSubject(subject string) bool {
+	return strings.HasPrefix(subject, c.statusSubjectPrefix+".")
+}
+
+// recordSeen updates the last-seen time of stored readings and announces recovered sensors
+func (c *DataConsumer) recordSeen(batch []SensorData) {
+	if c.staleness == nil {
+		return
+	}
+	now := time.Now()
+	for _, activity := range c.staleness.Seen(batch, now) {
+		c.publishStatus(StatusOnline, activity, now)
+	}
+}
+
+// runStalenessCheck periodically looks for sensors that stopped publishing
+func (c *DataConsumer) runStalenessCheck() {
+	ticker := time.NewTicker(c.staleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			for _, activity := range c.staleness.Expired(now, c.staleTimeout) {
+				c.publishStatus(StatusOffline, activity, now)
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// publishStatus publishes a status event and, if enabled, emails it
+func (c *DataConsumer) publishStatus(status string, activity *sensorActivity, now time.Time) {
+	data := activity.data
+	event := StatusEvent{
+		Status:           status,
+		SensorID:         data.SensorID,
+		SensorType:       data.SensorType,
+		Location:         data.Location,
+		Tenant:           data.Tenant,
+		LastSeen:         activity.lastSeen,
+		ExpectedInterval: c.expectedInterval(data).String(),
+		Time:             now,
+	}
+	log.Printf("Sensor %s is %s (last seen %s)", data.SensorID, status, activity.lastSeen.Format(time.RFC3339))
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode status event: %v", err)
+		return
+	}
+	if err := c.natsConn.Publish(c.statusSubjectPrefix+"."+status, payload); err != nil {
+		log.Printf("Failed to publish status event for sensor %s: %v", data.SensorID, err)
+	}
+
+	if !c.staleAlertEmails {
+		return
+	}
+	subject := fmt.Sprintf("Sensor Offline: %s", data.SensorID)
+	message := fmt.Sprintf(
+		"Sensor %s has not published for more than %s.\n\n"+
+			"Sensor ID: %s\n"+
+			"Type: %s\n"+
+			"Location: %s\n"+
+			"Last seen: %s",
+		data.SensorID, now.Sub(activity.lastSeen).Round(time.Second),
+		data.SensorID, data.SensorType, data.Location, activity.lastSeen.Format(time.RFC1123))
+	if status == StatusOnline {
+		subject = fmt.Sprintf("Sensor Back Online: %s", data.SensorID)
+		message = fmt.Sprintf(
+			"Sensor %s is publishing again.\n\n"+
+				"Sensor ID: %s\n"+
+				"Type: %s\n"+
+				"Location: %s\n"+
+				"Offline since: %s",
+			data.SensorID, data.SensorID, data.SensorType, data.Location, activity.lastSeen.Format(time.RFC1123))
+	}
+	if err := c.sendEmail(subject, message); err != nil {
+		log.Printf("Failed to send status email for sensor %s: %v", data.SensorID, err)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Subject template placeholders that map onto SensorData fields; any other placeholder becomes a tag
+const (
+	PlaceholderType     = "type"
+	PlaceholderID       = "id"
+	PlaceholderLocation = "location"
+)
+
+// Policies for readings whose payload disagrees with their subject
+const (
+	ConflictPolicyReject = "reject"
+	ConflictPolicyTag    = "tag"
+)
+
+// conflictTag is the tag listing the fields on which subject and payload disagree
+const conflictTag = "subjectConflict"
+
+// SubjectTemplate describes a hierarchical subject such as building.{building}.floor.{floor}.{type}.{id}
+type SubjectTemplate struct {
+	pattern string
+	tokens  []string
+}
+
+// ParseSubjectTemplate parses a dot-separated subject template with {name} placeholders
+func ParseSubjectTemplate(pattern string) (*SubjectTemplate, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return nil, fmt.Errorf("subject template is empty")
+	}
+
+	tokens := strings.Split(pattern, ".")
+	seen := make(map[string]bool)
+	for _, token := range tokens {
+		if token == "" || strings.ContainsAny(token, "*> ") {
+			return nil, fmt.Errorf("subject template %q has an invalid token %q", pattern, token)
+		}
+		if name, ok := placeholderName(token); ok {
+			if name == "" || seen[name] {
+				return nil, fmt.Errorf("subject template %q has an empty or repeated placeholder %q", pattern, token)
+			}
+			seen[name] = true
+		}
+	}
+
+	return &SubjectTemplate{pattern: pattern, tokens: tokens}, nil
+}
+
+// ParseSubjectTemplates parses a semicolon-separated list of subject templates
+func ParseSubjectTemplates(patterns string) ([]*SubjectTemplate, error) {
+	var templates []*SubjectTemplate
+	for _, pattern := range strings.Split(patterns, ";") {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		template, err := ParseSubjectTemplate(pattern)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+// placeholderName returns the name of a {name} token
+func placeholderName(token string) (string, bool) {
+	if strings.HasPrefix(token, "{") && strings.HasSuffix(token, "}") {
+		return token[1 : len(token)-1], true
+	}
+	return "", false
+}
+
+// Match returns the placeholder values of a subject, or false if the subject does not fit the template
+func (t *SubjectTemplate) Match(subject string) (map[string]string, bool) {
+	return t.matchTokens(strings.Split(subject, "."))
+}
+
+// matchTokens matches the tokens of a subject or topic against the template
+func (t *SubjectTemplate) matchTokens(parts []string) (map[string]string, bool) {
+	if len(parts) != len(t.tokens) {
+		return nil, false
+	}
+
+	values := make(map[string]string)
+	for i, token := range t.tokens {
+		if name, ok := placeholderName(token); ok {
+			values[name] = parts[i]
+		} else if token != parts[i] {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// Wildcard returns the NATS subject filter matching every subject of the template
+func (t *SubjectTemplate) Wildcard() string {
+	return t.filter(".", "*")
+}
+
+// filter joins the template tokens with sep, replacing placeholders with a single-token wildcard
+func (t *SubjectTemplate) filter(sep, wildcard string) string {
+	parts := make([]string, len(t.tokens))
+	for i, token := range t.tokens {
+		if _, ok := placeholderName(token); ok {
+			parts[i] = wildcard
+		} else {
+			parts[i] = token
+		}
+	}
+	return strings.Join(parts, sep)
+}
+
+// matchSubject returns the placeholder values of the first template matching a subject
+func (c *DataConsumer) matchSubject(subject string) map[string]string {
+	// Readings forwarded from the HTTP endpoint carry no subject information
+	if subject == c.httpIngestSubject {
+		return nil
+	}
+	for _, template := range c.subjectTemplates {
+		if values, ok := template.Match(subject); ok {
+			return values
+		}
+	}
+	return nil
+}
+
+// applySubject fills missing fields from the subject, checks the payload against it and
+// adds the remaining placeholders as tags. Subjects that match no template leave data unchanged.
+func (c *DataConsumer) applySubject(data SensorData, values map[string]string) (SensorData, error) {
+	if values == nil {
+		return data, nil
+	}
+
+	var conflicts []string
+	for name, value := range values {
+		var field *string
+		switch name {
+		case PlaceholderType:
+			field = &data.SensorType
+		case PlaceholderID:
+			field = &data.SensorID
+		case PlaceholderLocation:
+			field = &data.Location
+		case PlaceholderTenant:
+			field = &data.Tenant
+		default:
+			data = data.WithTag(name, value)
+			continue
+		}
+
+		if *field == "" {
+			*field = value
+		} else if *field != value {
+			conflicts = append(conflicts, name)
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return data, nil
+	}
+
+	sort.Strings(conflicts)
+	if c.subjectConflictPolicy == ConflictPolicyTag {
+		log.Printf("Subject and payload of sensor %q disagree on %s", data.SensorID, strings.Join(conflicts, ", "))
+		return data.WithTag(conflictTag, strings.Join(conflicts, ",")), nil
+	}
+	return data, &ValidationError{
+		Reason:  ReasonSubjectMismatch,
+		Message: fmt.Sprintf("payload disagrees with subject on %s", strings.Join(conflicts, ", ")),
+	}
+}
+
+// streamSubjects returns the subjects the sensor stream has to capture, including the
+// dead-letter subjects so that dead-lettered messages are stored before the original is dropped
+func (c *DataConsumer) streamSubjects() []string {
+	subjects := []string{sensorSubjects}
+	seen := map[string]bool{sensorSubjects: true}
+	if deadLetters := c.dlqSubjectPrefix + ".>"; !strings.HasPrefix(deadLetters, "sensors.") {
+		seen[deadLetters] = true
+		subjects = append(subjects, deadLetters)
+	}
+	if c.httpIngestSubject != "" && !strings.HasPrefix(c.httpIngestSubject, "sensors.") {
+		seen[c.httpIngestSubject] = true
+		subjects = append(subjects, c.httpIngestSubject)
+	}
+	for _, template := range c.subjectTemplates {
+		wildcard := template.Wildcard()
+		if strings.HasPrefix(wildcard, "sensors.") || seen[wildcard] {
+			continue
+		}
+		seen[wildcard] = true
+		subjects = append(subjects, wildcard)
+	}
+	return subjects
+}
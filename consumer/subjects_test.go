@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSubjectTemplateMatch(t *testing.T) {
+	template, err := ParseSubjectTemplate("building.{building}.floor.{floor}.{type}.{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wildcard := template.Wildcard(); wildcard != "building.*.floor.*.*.*" {
+		t.Errorf("got wildcard %q", wildcard)
+	}
+
+	tests := []struct {
+		subject string
+		want    map[string]string
+	}{
+		{
+			subject: "building.hq.floor.3.temperature.temp_001",
+			want:    map[string]string{"building": "hq", "floor": "3", "type": "temperature", "id": "temp_001"},
+		},
+		{subject: "building.hq.level.3.temperature.temp_001"},
+		{subject: "building.hq.floor.3.temperature"},
+		{subject: "building.hq.floor.3.temperature.temp_001.extra"},
+		{subject: "sensors.temperature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			values, ok := template.Match(tt.subject)
+			if ok != (tt.want != nil) || !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("got %v (%v), want %v", values, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubjectTemplates(t *testing.T) {
+	templates, err := ParseSubjectTemplates(" building.{building}.{type}.{id} ; ;site.{site}.{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 {
+		t.Fatalf("got %d templates, want 2", len(templates))
+	}
+
+	for _, pattern := range []string{"building..{id}", "building.*.{id}", "building.>", "building.{}.{id}", "{id}.{id}", "building.{a b}"} {
+		if _, err := ParseSubjectTemplates(pattern); err == nil {
+			t.Errorf("%q: expected an error", pattern)
+		}
+	}
+}
+
+func TestApplySubject(t *testing.T) {
+	values := map[string]string{"building": "hq", "type": "temperature", "id": "temp_001", "location": "lobby"}
+
+	tests := []struct {
+		name    string
+		policy  string
+		payload SensorData
+		want    SensorData
+		// wantErr expects the reading to be rejected for disagreeing with its subject
+		wantErr bool
+	}{
+		{
+			name:    "fills missing fields",
+			policy:  ConflictPolicyReject,
+			payload: SensorData{Value: 21.5},
+			want:    SensorData{SensorType: "temperature", SensorID: "temp_001", Location: "lobby", Value: 21.5, Tags: map[string]string{"building": "hq"}},
+		},
+		{
+			name:    "matching payload",
+			policy:  ConflictPolicyReject,
+			payload: SensorData{SensorType: "temperature", SensorID: "temp_001", Location: "lobby", Value: 21.5},
+			want:    SensorData{SensorType: "temperature", SensorID: "temp_001", Location: "lobby", Value: 21.5, Tags: map[string]string{"building": "hq"}},
+		},
+		{
+			name:    "conflict rejected",
+			policy:  ConflictPolicyReject,
+			payload: SensorData{SensorType: "humidity", SensorID: "temp_001", Value: 21.5},
+			wantErr: true,
+		},
+		{
+			name:    "conflicts tagged",
+			policy:  ConflictPolicyTag,
+			payload: SensorData{SensorType: "humidity", SensorID: "temp_002", Location: "lobby", Value: 21.5},
+			want: SensorData{SensorType: "humidity", SensorID: "temp_002", Location: "lobby", Value: 21.5,
+				Tags: map[string]string{"building": "hq", conflictTag: "id,type"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &DataConsumer{subjectConflictPolicy: tt.policy}
+			data, err := c.applySubject(tt.payload, values)
+			if tt.wantErr {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) || validationErr.Reason != ReasonSubjectMismatch {
+					t.Errorf("got error %v, want reason %s", err, ReasonSubjectMismatch)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(data, tt.want) {
+				t.Errorf("got %+v, want %+v", data, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamSubjects(t *testing.T) {
+	template, err := ParseSubjectTemplate("building.{building}.{type}.{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := ParseSubjectTemplate("sensors.{building}.{type}.{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &DataConsumer{
+		dlqSubjectPrefix:  "dlq.sensors",
+		httpIngestSubject: "sensors.http",
+		subjectTemplates:  []*SubjectTemplate{template, nested, template},
+	}
+	want := []string{sensorSubjects, "dlq.sensors.>", "building.*.*.*"}
+	if subjects := c.streamSubjects(); !reflect.DeepEqual(subjects, want) {
+		t.Errorf("got %v, want %v", subjects, want)
+	}
+}
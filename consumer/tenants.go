@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// HeaderTenantID names the tenant of a NATS message or HTTP request
+const HeaderTenantID = "Tenant-Id"
+
+// PlaceholderTenant is the subject and topic placeholder naming the tenant
+const PlaceholderTenant = "tenant"
+
+// tenantNamePattern restricts tenant names so they can be used in paths
+var tenantNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// TenantRoute is the InfluxDB destination of one tenant. Empty fields fall back to the
+// consumer's InfluxDB configuration.
+type TenantRoute struct {
+	URL    string `json:"url"`
+	Org    string `json:"org"`
+	Token  string `json:"token"`
+	Bucket string `json:"bucket"`
+}
+
+// LoadTenantRoutes reads a JSON routing table mapping tenant names to their InfluxDB destination
+func LoadTenantRoutes(path string) (map[string]TenantRoute, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant routes: %w", err)
+	}
+
+	var routes map[string]TenantRoute
+	if err := json.Unmarshal(raw, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant routes %s: %w", path, err)
+	}
+	for name, route := range routes {
+		if !tenantNamePattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid tenant name %q", name)
+		}
+		if route.Bucket == "" {
+			return nil, fmt.Errorf("tenant %q has no bucket", name)
+		}
+	}
+	return routes, nil
+}
+
+// applyTenantHeader assigns the tenant named by a message header to readings that name none
+func applyTenantHeader(readings []DecodedReading, tenant string) {
+	if tenant == "" {
+		return
+	}
+	for i := range readings {
+		if readings[i].Data.Tenant == "" {
+			readings[i].Data.Tenant = tenant
+		}
+	}
+}
+
+// checkTenant rejects readings of tenants missing from the routing table
+func (c *DataConsumer) checkTenant(data SensorData) error {
+	if c.tenantRoutes == nil || data.Tenant == "" {
+		return nil
+	}
+	if _, ok := c.tenantRoutes[data.Tenant]; !ok {
+		return &ValidationError{Reason: ReasonUnknownTenant, Message: fmt.Sprintf("unknown tenant %q", data.Tenant)}
+	}
+	return nil
+}
+
+// TenantInfluxSink routes readings to the InfluxDB sink of their tenant. Readings without
+// a tenant go to the default sink.
+type TenantInfluxSink struct {
+	defaultSink *InfluxSink
+	tenants     map[string]*InfluxSink
+}
+
+// Name returns the sink name
+func (s *TenantInfluxSink) Name() string {
+	return SinkInflux
+}
+
+// Write splits the batch by tenant and writes each part to its tenant's bucket
+func (s *TenantInfluxSink) Write(ctx context.Context, batch []SensorData) error {
+	parts := make(map[string][]SensorData)
+	var order []string
+	for _, data := range batch {
+		if _, ok := parts[data.Tenant]; !ok {
+			order = append(order, data.Tenant)
+		}
+		parts[data.Tenant] = append(parts[data.Tenant], data)
+	}
+
+	var errs []error
+	for _, tenant := range order {
+		sink := s.defaultSink
+		if tenant != "" {
+			sink = s.tenants[tenant]
+		}
+		if sink == nil {
+			errs = append(errs, fmt.Errorf("no route for tenant %q", tenant))
+			continue
+		}
+		if err := sink.Write(ctx, parts[tenant]); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %q: %w", tenant, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Check checks the InfluxDB instance and bucket of the default sink and of every tenant
+func (s *TenantInfluxSink) Check(ctx context.Context) error {
+	errs := []error{s.defaultSink.Check(ctx)}
+	for name, sink := range s.tenants {
+		if err := sink.Check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the sinks of all tenants
+func (s *TenantInfluxSink) Close() error {
+	var errs []error
+	for _, sink := range s.tenants {
+		errs = append(errs, sink.Close())
+	}
+	errs = append(errs, s.defaultSink.Close())
+	return errors.Join(errs...)
+}
+
+// newTenantInfluxSink creates one InfluxDB sink per routed tenant next to the default sink.
+// Every tenant gets its own spool directory so replayed points reach the right bucket.
+func (c *DataConsumer) newTenantInfluxSink(defaultSink *InfluxSink) (*TenantInfluxSink, error) {
+	s := &TenantInfluxSink{defaultSink: defaultSink, tenants: make(map[string]*InfluxSink)}
+
+	names := make([]string, 0, len(c.tenantRoutes))
+	for name := range c.tenantRoutes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		route := c.tenantRoutes[name]
+		url, org, token := c.influxURL, c.influxOrg, c.influxToken
+		if route.URL != "" {
+			url = route.URL
+		}
+		if route.Org != "" {
+			org = route.Org
+		}
+		if route.Token != "" {
+			token = route.Token
+		}
+
+		var spool *Spool
+		if c.spoolDir != "" {
+			var err error
+			spool, err = OpenSpool(filepath.Join(c.spoolDir, "tenant-"+name), c.spoolSegmentSize, c.spoolMaxBytes)
+			if err != nil {
+				s.Close()
+				return nil, err
+			}
+			spoolMetrics.Add(name, spool)
+		}
+
+		sink := NewInfluxSink(url, token, org, route.Bucket, spool, c.spoolReplayBatchSize)
+		if spool != nil {
+			go sink.RunReplay(c.ctx, c.spoolReplayInterval)
+		}
+		s.tenants[name] = sink
+		log.Printf("Routing tenant %s to bucket %s in org %s", name, route.Bucket, org)
+	}
+	return s, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareReadingsRejectsUnknownTenants(t *testing.T) {
+	timestamp := time.Date(2025, 5, 12, 10, 0, 0, 0, time.UTC)
+	reading := func(tenant string) DecodedReading {
+		return DecodedReading{Data: SensorData{SensorType: "temperature", SensorID: "temp_001", Value: 21.5, Unit: "°C", Tenant: tenant, Timestamp: timestamp}}
+	}
+
+	tests := []struct {
+		name   string
+		routes map[string]TenantRoute
+		tenant string
+		// wantRejected expects the reading to be rejected as belonging to an unknown tenant
+		wantRejected bool
+	}{
+		{name: "routed tenant", routes: map[string]TenantRoute{"acme": {Bucket: "acme"}}, tenant: "acme"},
+		{name: "unknown tenant", routes: map[string]TenantRoute{"acme": {Bucket: "acme"}}, tenant: "globex", wantRejected: true},
+		{name: "no tenant goes to the default bucket", routes: map[string]TenantRoute{"acme": {Bucket: "acme"}}},
+		{name: "any tenant without a routing table", tenant: "globex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &DataConsumer{tenantRoutes: tt.routes}
+			valid, rejected := c.prepareReadings(nil, []DecodedReading{reading(tt.tenant)})
+			if !tt.wantRejected {
+				if len(valid) != 1 || len(rejected) != 0 {
+					t.Fatalf("got %d valid and %d rejected readings, want the reading to be valid", len(valid), len(rejected))
+				}
+				return
+			}
+			if len(valid) != 0 || len(rejected) != 1 {
+				t.Fatalf("got %d valid and %d rejected readings, want the reading to be rejected", len(valid), len(rejected))
+			}
+			var validationErr *ValidationError
+			if !errors.As(rejected[0].Err, &validationErr) || validationErr.Reason != ReasonUnknownTenant {
+				t.Errorf("got error %v, want reason %s", rejected[0].Err, ReasonUnknownTenant)
+			}
+		})
+	}
+}
+
+func TestLoadTenantRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "valid", content: `{"acme": {"bucket": "acme"}, "globex-2": {"url": "http://influx:8086", "bucket": "globex"}}`},
+		{name: "invalid name", content: `{"../acme": {"bucket": "acme"}}`, wantErr: "invalid tenant name"},
+		{name: "missing bucket", content: `{"acme": {"org": "acme"}}`, wantErr: "has no bucket"},
+		{name: "not json", content: `acme: {bucket: acme}`, wantErr: "failed to parse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tenants.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			routes, err := LoadTenantRoutes(path)
+			if tt.wantErr == "" {
+				if err != nil || len(routes) != 2 {
+					t.Errorf("got %d routes and error %v, want 2 routes", len(routes), err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unitConversion converts a value from an alternative unit into the canonical unit
+type unitConversion func(value float64) float64
+
+// canonicalUnits maps each sensor type to the unit its values are stored in
+var canonicalUnits = map[string]string{
+	"temperature": "°C",
+	"humidity":    "%",
+	"electricity": "kW",
+}
+
+// Conversions from the units accepted for each sensor type into its canonical unit
+var (
+	fromFahrenheit unitConversion = func(v float64) float64 { return (v - 32) * 5 / 9 }
+	fromKelvin     unitConversion = func(v float64) float64 { return v - 273.15 }
+	fromFraction   unitConversion = func(v float64) float64 { return v * 100 }
+	fromWatt       unitConversion = func(v float64) float64 { return v / 1000 }
+	fromMegawatt   unitConversion = func(v float64) float64 { return v * 1000 }
+)
+
+// unitConversions maps each sensor type to the units it accepts. Units are matched by their
+// exact spelling, since SI prefixes differ only in case ("mW" is not "MW"), so only the
+// official symbols and names are listed, never case-folded variants such as "kw" or "KW".
+var unitConversions = map[string]map[string]unitConversion{
+	"temperature": {
+		"°C":         identity,
+		"C":          identity,
+		"degC":       identity,
+		"celsius":    identity,
+		"Celsius":    identity,
+		"°F":         fromFahrenheit,
+		"F":          fromFahrenheit,
+		"degF":       fromFahrenheit,
+		"fahrenheit": fromFahrenheit,
+		"Fahrenheit": fromFahrenheit,
+		"K":          fromKelvin,
+		"kelvin":     fromKelvin,
+		"Kelvin":     fromKelvin,
+	},
+	"humidity": {
+		"%":        identity,
+		"%RH":      identity,
+		"% RH":     identity,
+		"percent":  identity,
+		"fraction": fromFraction,
+		"ratio":    fromFraction,
+	},
+	"electricity": {
+		"kW": identity,
+		"W":  fromWatt,
+		"MW": fromMegawatt,
+	},
+}
+
+// identity leaves a value unchanged
+func identity(v float64) float64 { return v }
+
+// NormalizeUnit converts a reading into the canonical unit for its sensor type.
+// Readings without a unit are assumed to already be in the canonical unit, and
+// readings of unknown sensor types are left for ValidateSensorData to reject.
+func NormalizeUnit(data SensorData) (SensorData, error) {
+	canonical, ok := canonicalUnits[data.SensorType]
+	if !ok {
+		return data, nil
+	}
+
+	unit := strings.TrimSpace(data.Unit)
+	if unit == "" {
+		data.Unit = canonical
+		return data, nil
+	}
+
+	convert, ok := unitConversions[data.SensorType][unit]
+	if !ok {
+		return data, &ValidationError{Reason: ReasonBadUnit, Message: fmt.Sprintf("unit %q is not supported for %s", unit, data.SensorType)}
+	}
+
+	data.Value = convert(data.Value)
+	data.Unit = canonical
+	return data, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestNormalizeUnit(t *testing.T) {
+	tests := []struct {
+		sensorType string
+		unit       string
+		value      float64
+		want       float64
+		wantUnit   string
+		// wantErr expects the unit to be rejected
+		wantErr bool
+	}{
+		{sensorType: "temperature", unit: "°C", value: 21.5, want: 21.5, wantUnit: "°C"},
+		{sensorType: "temperature", unit: "", value: 21.5, want: 21.5, wantUnit: "°C"},
+		{sensorType: "temperature", unit: " °F ", value: 212, want: 100, wantUnit: "°C"},
+		{sensorType: "temperature", unit: "K", value: 273.15, want: 0, wantUnit: "°C"},
+		{sensorType: "temperature", unit: "k", wantErr: true},
+		{sensorType: "temperature", unit: "kW", wantErr: true},
+		{sensorType: "humidity", unit: "%RH", value: 45, want: 45, wantUnit: "%"},
+		{sensorType: "humidity", unit: "fraction", value: 0.45, want: 45, wantUnit: "%"},
+		{sensorType: "humidity", unit: "%rh", wantErr: true},
+		{sensorType: "electricity", unit: "kW", value: 2.5, want: 2.5, wantUnit: "kW"},
+		{sensorType: "electricity", unit: "W", value: 2500, want: 2.5, wantUnit: "kW"},
+		{sensorType: "electricity", unit: "MW", value: 0.0025, want: 2.5, wantUnit: "kW"},
+		{sensorType: "electricity", unit: "mW", wantErr: true},
+		{sensorType: "electricity", unit: "kw", wantErr: true},
+		{sensorType: "electricity", unit: "KW", wantErr: true},
+		{sensorType: "electricity", unit: "w", wantErr: true},
+		{sensorType: "pressure", unit: "hPa", value: 1013, want: 1013, wantUnit: "hPa"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sensorType+" "+tt.unit, func(t *testing.T) {
+			data, err := NormalizeUnit(SensorData{SensorType: tt.sensorType, Unit: tt.unit, Value: tt.value})
+			if tt.wantErr {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) || validationErr.Reason != ReasonBadUnit {
+					t.Fatalf("got error %v, want reason %s", err, ReasonBadUnit)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if math.Abs(data.Value-tt.want) > 1e-9 || data.Unit != tt.wantUnit {
+				t.Errorf("got %v %s, want %v %s", data.Value, data.Unit, tt.want, tt.wantUnit)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Rejection reasons, used as the last token of the dead-letter subject
+const (
+	ReasonDecode            = "decode"
+	ReasonMissingField      = "missing_field"
+	ReasonUnknownType       = "unknown_type"
+	ReasonNotFinite         = "not_finite"
+	ReasonOutOfRange        = "out_of_range"
+	ReasonBadTimestamp      = "bad_timestamp"
+	ReasonBadUnit           = "bad_unit"
+	ReasonUnsupportedFormat = "unsupported_format"
+	ReasonSubjectMismatch   = "subject_mismatch"
+	ReasonUnknownTenant     = "unknown_tenant"
+	ReasonUndeliverable     = "undeliverable"
+)
+
+// ValidationError describes why a reading was rejected
+type ValidationError struct {
+	Reason  string
+	Message string
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// ValueRange is the inclusive range of physically plausible values for a sensor type
+type ValueRange struct {
+	Min float64
+	Max float64
+}
+
+// sensorTypeRanges lists the known sensor types and their plausible value ranges
+var sensorTypeRanges = map[string]ValueRange{
+	"temperature": {Min: -50.0, Max: 150.0}, // °C
+	"humidity":    {Min: 0.0, Max: 100.0},   // % RH
+	"electricity": {Min: 0.0, Max: 10000.0}, // kW
+}
+
+// ValidateSensorData checks required fields, value ranges and timestamp sanity of a reading
+func ValidateSensorData(data SensorData, now time.Time, maxSkew, maxAge time.Duration) error {
+	// Required fields
+	if data.SensorID == "" {
+		return &ValidationError{Reason: ReasonMissingField, Message: "sensorId is empty"}
+	}
+	if data.SensorType == "" {
+		return &ValidationError{Reason: ReasonMissingField, Message: "sensorType is empty"}
+	}
+	if data.Timestamp.IsZero() {
+		return &ValidationError{Reason: ReasonMissingField, Message: "timestamp is missing"}
+	}
+
+	// Sensor type and physical range
+	valueRange, ok := sensorTypeRanges[data.SensorType]
+	if !ok {
+		return &ValidationError{Reason: ReasonUnknownType, Message: fmt.Sprintf("unknown sensor type %q", data.SensorType)}
+	}
+	if math.IsNaN(data.Value) || math.IsInf(data.Value, 0) {
+		return &ValidationError{Reason: ReasonNotFinite, Message: fmt.Sprintf("value %v is not a finite number", data.Value)}
+	}
+	if data.Value < valueRange.Min || data.Value > valueRange.Max {
+		return &ValidationError{
+			Reason:  ReasonOutOfRange,
+			Message: fmt.Sprintf("%s value %v outside [%v, %v]", data.SensorType, data.Value, valueRange.Min, valueRange.Max),
+		}
+	}
+
+	// Timestamp sanity
+	if maxSkew > 0 && data.Timestamp.After(now.Add(maxSkew)) {
+		return &ValidationError{Reason: ReasonBadTimestamp, Message: fmt.Sprintf("timestamp %s is in the future", data.Timestamp.Format(time.RFC3339))}
+	}
+	if maxAge > 0 && data.Timestamp.Before(now.Add(-maxAge)) {
+		return &ValidationError{Reason: ReasonBadTimestamp, Message: fmt.Sprintf("timestamp %s is too old", data.Timestamp.Format(time.RFC3339))}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nats-io/nats.go"
+)
+
+// Overload policies for a full worker queue
+const (
+	// OverloadBlock makes the NATS or MQTT callback wait until the queue has room
+	OverloadBlock = "block"
+	// OverloadShed drops the lowest-priority queued message in favour of a higher-priority one
+	OverloadShed = "shed"
+	// OverloadNak hands the incoming message back to JetStream for later redelivery
+	OverloadNak = "nak"
+)
+
+// rejectShutdown is the reason given for jobs submitted after the pool was stopped
+const rejectShutdown = "worker pool is shutting down"
+
+// job is a decoded message waiting for a worker. It holds either a JetStream message or,
+// for the MQTT bridge, an MQTT message.
+type job struct {
+	msg      *nats.Msg
+	mqttMsg  mqtt.Message
+	readings []DecodedReading
+	msgKey   string
+	priority int
+
+	// mqttSeq is the position of an MQTT message in the order the client received them
+	mqttSeq uint64
+	// attempt counts how often an MQTT message was processed or turned away
+	attempt int
+}
+
+// workerQueue is the bounded FIFO queue of a single worker
+type workerQueue struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	jobs     []*job
+	capacity int
+	closed   bool
+}
+
+// WorkerPoolStats describes the load of a worker pool
+type WorkerPoolStats struct {
+	Workers    int
+	QueueDepth int
+	Submitted  uint64
+	Processed  uint64
+	Shed       uint64
+	Rejected   uint64
+}
+
+// WorkerPool processes jobs on a fixed number of workers. Jobs with the same key
+// always go to the same worker, so they are processed in the order they arrived.
+type WorkerPool struct {
+	queues []*workerQueue
+	policy string
+	handle func(*job)
+	reject func(*job, string)
+	wg     sync.WaitGroup
+
+	submitted atomic.Uint64
+	processed atomic.Uint64
+	shed      atomic.Uint64
+	rejected  atomic.Uint64
+}
+
+// NewWorkerPool creates a pool of workers with one bounded queue each. handle processes
+// a job; reject is called with a reason for jobs that are turned away under overload.
+func NewWorkerPool(workers, queueSize int, policy string, handle func(*job), reject func(*job, string)) (*WorkerPool, error) {
+	if workers < 1 || queueSize < 1 {
+		return nil, fmt.Errorf("worker pool needs at least one worker and a queue size of at least one")
+	}
+	if policy != OverloadBlock && policy != OverloadShed && policy != OverloadNak {
+		return nil, fmt.Errorf("invalid overload policy %q", policy)
+	}
+
+	p := &WorkerPool{policy: policy, handle: handle, reject: reject}
+	for i := 0; i < workers; i++ {
+		q := &workerQueue{capacity: queueSize}
+		q.notEmpty = sync.NewCond(&q.mu)
+		q.notFull = sync.NewCond(&q.mu)
+		p.queues = append(p.queues, q)
+	}
+	return p, nil
+}
+
+// Start launches the workers
+func (p *WorkerPool) Start() {
+	for _, q := range p.queues {
+		p.wg.Add(1)
+		go p.work(q)
+	}
+}
+
+// work processes the jobs of one queue until it is closed and drained
+func (p *WorkerPool) work(q *workerQueue) {
+	defer p.wg.Done()
+
+	for {
+		q.mu.Lock()
+		for len(q.jobs) == 0 && !q.closed {
+			q.notEmpty.Wait()
+		}
+		if len(q.jobs) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		j := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		q.notFull.Signal()
+		q.mu.Unlock()
+
+		p.handle(j)
+		p.processed.Add(1)
+	}
+}
+
+// Submit queues a job on the worker owning key, applying the overload policy if that queue is full
+func (p *WorkerPool) Submit(key string, j *job) {
+	p.submitted.Add(1)
+
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	q := p.queues[hash.Sum32()%uint32(len(p.queues))]
+
+	q.mu.Lock()
+	if p.policy == OverloadBlock {
+		for len(q.jobs) >= q.capacity && !q.closed {
+			q.notFull.Wait()
+		}
+	}
+
+	if q.closed {
+		q.mu.Unlock()
+		p.rejected.Add(1)
+		p.reject(j, rejectShutdown)
+		return
+	}
+
+	if len(q.jobs) < q.capacity {
+		q.jobs = append(q.jobs, j)
+		q.notEmpty.Signal()
+		q.mu.Unlock()
+		return
+	}
+
+	if p.policy == OverloadShed {
+		// Evict the most recently queued job of the lowest priority if the new job outranks it
+		victim := -1
+		for i := len(q.jobs) - 1; i >= 0; i-- {
+			if q.jobs[i].priority < j.priority && (victim < 0 || q.jobs[i].priority < q.jobs[victim].priority) {
+				victim = i
+			}
+		}
+		if victim >= 0 {
+			shed := q.jobs[victim]
+			q.jobs = append(q.jobs[:victim], q.jobs[victim+1:]...)
+			q.jobs = append(q.jobs, j)
+			q.notEmpty.Signal()
+			q.mu.Unlock()
+			p.shed.Add(1)
+			p.reject(shed, "shed for a higher-priority message")
+			return
+		}
+		q.mu.Unlock()
+		p.shed.Add(1)
+		p.reject(j, "shed because the queue is full of messages with the same or higher priority")
+		return
+	}
+
+	q.mu.Unlock()
+	p.rejected.Add(1)
+	p.reject(j, "worker queue is full")
+}
+
+// Stop closes the queues and waits for the workers to finish the queued jobs
+func (p *WorkerPool) Stop() {
+	for _, q := range p.queues {
+		q.mu.Lock()
+		q.closed = true
+		q.notEmpty.Broadcast()
+		q.notFull.Broadcast()
+		q.mu.Unlock()
+	}
+	p.wg.Wait()
+}
+
+// Stats returns the current queue depth and job counters
+func (p *WorkerPool) Stats() WorkerPoolStats {
+	stats := WorkerPoolStats{
+		Workers:   len(p.queues),
+		Submitted: p.submitted.Load(),
+		Processed: p.processed.Load(),
+		Shed:      p.shed.Load(),
+		Rejected:  p.rejected.Load(),
+	}
+	for _, q := range p.queues {
+		q.mu.Lock()
+		stats.QueueDepth += len(q.jobs)
+		q.mu.Unlock()
+	}
+	return stats
+}
+
+// ParsePriorities parses a list such as "temperature=3,humidity=2" into priorities per sensor type
+func ParsePriorities(list string) (map[string]int, error) {
+	priorities := make(map[string]int)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		sensorType, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid priority %q, expected type=priority", entry)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority %q: %w", entry, err)
+		}
+		priorities[strings.TrimSpace(sensorType)] = priority
+	}
+	return priorities, nil
+}
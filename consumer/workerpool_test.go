@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// poolRecorder records the jobs a worker pool handles and rejects, by message key
+type poolRecorder struct {
+	mu       sync.Mutex
+	handled  []string
+	rejected []string
+	reasons  []string
+}
+
+func (r *poolRecorder) handle(j *job) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handled = append(r.handled, j.msgKey)
+}
+
+func (r *poolRecorder) reject(j *job, reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rejected = append(r.rejected, j.msgKey)
+	r.reasons = append(r.reasons, reason)
+}
+
+func TestWorkerPoolOverload(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		// queued fills the only queue with jobs of these priorities, named q0, q1, ...
+		queued []int
+		// incoming is the priority of the job submitted to the full queue, named in
+		incoming     int
+		wantHandled  []string
+		wantRejected []string
+	}{
+		{
+			name:        "block waits for room",
+			policy:      OverloadBlock,
+			queued:      []int{1, 1},
+			incoming:    1,
+			wantHandled: []string{"q0", "q1", "in"},
+		},
+		{
+			name:         "nak turns the incoming job away",
+			policy:       OverloadNak,
+			queued:       []int{1, 1},
+			incoming:     3,
+			wantHandled:  []string{"q0", "q1"},
+			wantRejected: []string{"in"},
+		},
+		{
+			name:         "shed evicts a lower priority",
+			policy:       OverloadShed,
+			queued:       []int{1, 2},
+			incoming:     3,
+			wantHandled:  []string{"q1", "in"},
+			wantRejected: []string{"q0"},
+		},
+		{
+			name:         "shed picks the lowest priority, most recent first",
+			policy:       OverloadShed,
+			queued:       []int{2, 1, 3, 1, 2},
+			incoming:     2,
+			wantHandled:  []string{"q0", "q1", "q2", "q4", "in"},
+			wantRejected: []string{"q3"},
+		},
+		{
+			name:         "shed drops the incoming job without a lower priority",
+			policy:       OverloadShed,
+			queued:       []int{2, 3},
+			incoming:     2,
+			wantHandled:  []string{"q0", "q1"},
+			wantRejected: []string{"in"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &poolRecorder{}
+			pool, err := NewWorkerPool(1, len(tt.queued), tt.policy, recorder.handle, recorder.reject)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, priority := range tt.queued {
+				pool.Submit("temp_001", &job{msgKey: fmt.Sprintf("q%d", i), priority: priority})
+			}
+
+			submitted := make(chan struct{})
+			go func() {
+				pool.Submit("temp_001", &job{msgKey: "in", priority: tt.incoming})
+				close(submitted)
+			}()
+			if tt.policy == OverloadBlock {
+				select {
+				case <-submitted:
+					t.Fatal("submit to a full queue returned under the block policy")
+				case <-time.After(50 * time.Millisecond):
+				}
+			} else {
+				<-submitted
+			}
+
+			pool.Start()
+			<-submitted
+			pool.Stop()
+
+			if !reflect.DeepEqual(recorder.handled, tt.wantHandled) {
+				t.Errorf("handled %v, want %v", recorder.handled, tt.wantHandled)
+			}
+			if !reflect.DeepEqual(recorder.rejected, tt.wantRejected) {
+				t.Errorf("rejected %v, want %v", recorder.rejected, tt.wantRejected)
+			}
+		})
+	}
+}
+
+func TestWorkerPoolKeepsOrderPerKey(t *testing.T) {
+	const keys, jobsPerKey = 8, 200
+
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	handle := func(j *job) {
+		var key string
+		var seq int
+		fmt.Sscanf(j.msgKey, "%s %d", &key, &seq)
+		mu.Lock()
+		seen[key] = append(seen[key], seq)
+		mu.Unlock()
+	}
+	pool, err := NewWorkerPool(4, 4, OverloadBlock, handle, func(*job, string) { t.Error("job rejected") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+
+	for seq := 0; seq < jobsPerKey; seq++ {
+		for k := 0; k < keys; k++ {
+			key := fmt.Sprintf("sensor_%d", k)
+			pool.Submit(key, &job{msgKey: fmt.Sprintf("%s %d", key, seq)})
+		}
+	}
+	pool.Stop()
+
+	if len(seen) != keys {
+		t.Fatalf("got jobs of %d keys, want %d", len(seen), keys)
+	}
+	for key, seqs := range seen {
+		if len(seqs) != jobsPerKey {
+			t.Errorf("%s: handled %d jobs, want %d", key, len(seqs), jobsPerKey)
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Errorf("%s: job %d handled at position %d", key, seq, i)
+				break
+			}
+		}
+	}
+	if stats := pool.Stats(); stats.Processed != keys*jobsPerKey || stats.QueueDepth != 0 {
+		t.Errorf("got %d processed and %d queued, want %d and 0", stats.Processed, stats.QueueDepth, keys*jobsPerKey)
+	}
+}
+
+func TestWorkerPoolRejectsAfterStop(t *testing.T) {
+	recorder := &poolRecorder{}
+	pool, err := NewWorkerPool(2, 1, OverloadBlock, recorder.handle, recorder.reject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.Start()
+	pool.Stop()
+
+	pool.Submit("temp_001", &job{msgKey: "late"})
+	if len(recorder.handled) != 0 || !reflect.DeepEqual(recorder.reasons, []string{rejectShutdown}) {
+		t.Errorf("got handled %v and reasons %q, want the job rejected for shutdown", recorder.handled, recorder.reasons)
+	}
+}
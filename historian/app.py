@@ -104,6 +104,7 @@ def get_sensor_readings(
     query = f'''
     from(bucket: "{INFLUXDB_RAW_BUCKET}")
         |> range(start: {startTime}, stop: {endTime})
+        |> filter(fn: (r) => r._field == "value")
     '''
     
     # Add filters if provided
@@ -135,7 +136,7 @@ def get_sensor_readings(
                     sensorType=record.values.get("_measurement", ""),
                     location=record.values.get("location", ""),
                     value=record.values.get("_value", 0.0),
-                    unit=get_unit_by_sensorType(record.values.get("_measurement", "")),
+                    unit=record.values.get("unit") or get_unit_by_sensorType(record.values.get("_measurement", "")),
                     timestamp=record.values.get("_time").isoformat()
                 )
                 readings.append(reading)
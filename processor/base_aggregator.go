@@ -6,10 +6,12 @@ import (
 
 // BaseAggregator defines the interface for all sensor aggregators
 type BaseAggregator interface {
+	Name() string
 	Setup() error
 	Run() error
 	Shutdown()
 	RunAggregation()
+	Ready(ctx context.Context) error
 	GetCancelFunc() context.CancelFunc
 }
 
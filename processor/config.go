@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 )
 
 // Config holds the application configuration
@@ -13,8 +14,18 @@ type Config struct {
 	SourceBucket string
 	TargetBucket string
 
+	// Tenant configuration
+	TenantRoutesFile string
+
 	// Aggregation configuration
 	AggregationInterval string
+	AggregateQualities  string
+
+	// HTTP configuration
+	HTTPAddr string
+
+	// Health configuration
+	ReadyMaxRunAge time.Duration
 }
 
 // NewConfig creates a new Config instance with values from environment variables
@@ -25,7 +36,11 @@ func NewConfig() *Config {
 		InfluxOrg:           getEnv("INFLUXDB_ORG", "acme_corp"),
 		SourceBucket:        getEnv("INFLUXDB_SOURCE_BUCKET", "sensor_data"),
 		TargetBucket:        getEnv("INFLUXDB_TARGET_BUCKET", "aggregated_data"),
+		TenantRoutesFile:    getEnv("TENANT_ROUTES_FILE", ""),
 		AggregationInterval: getEnv("AGGREGATION_INTERVAL", "30m"),
+		AggregateQualities:  getEnv("AGGREGATE_QUALITIES", "good"),
+		HTTPAddr:            getEnv("HTTP_ADDR", ":8080"),
+		ReadyMaxRunAge:      getEnvDuration("READY_MAX_RUN_AGE", 0),
 	}
 }
 
@@ -37,3 +52,17 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvDuration gets an environment variable as a time.Duration or returns a default value
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	durationValue, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return durationValue
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 )
 
 // ElectricityAggregator handles aggregating electricity data in InfluxDB
@@ -19,13 +18,18 @@ type ElectricityAggregator struct {
 	sourceBucket string
 	targetBucket string
 
+	// Tenant configuration
+	tenantRoutesFile string
+
 	// Aggregation configuration
 	aggregationInterval string
+	aggregateQualities  string
+
+	// Health configuration
+	readyMaxRunAge time.Duration
 
-	// Clients
-	influxClient influxdb2.Client
-	queryAPI     api.QueryAPI
-	writeAPI     api.WriteAPIBlocking
+	// Clients, one per tenant
+	tenants []*TenantClient
 
 	// For graceful shutdown
 	ctx        context.Context
@@ -42,26 +46,45 @@ func NewElectricityAggregator(config *Config) *ElectricityAggregator {
 		influxOrg:           config.InfluxOrg,
 		sourceBucket:        config.SourceBucket,
 		targetBucket:        config.TargetBucket,
+		tenantRoutesFile:    config.TenantRoutesFile,
 		aggregationInterval: config.AggregationInterval,
+		aggregateQualities:  config.AggregateQualities,
+		readyMaxRunAge:      config.ReadyMaxRunAge,
 		ctx:                 ctx,
 		cancelFunc:          cancel,
 	}
 }
 
+// Name returns the sensor type this aggregator handles
+func (a *ElectricityAggregator) Name() string {
+	return "electricity"
+}
+
 // GetCancelFunc returns the cancel function for this aggregator
 func (a *ElectricityAggregator) GetCancelFunc() context.CancelFunc {
 	return a.cancelFunc
 }
 
-// Setup initializes connections to InfluxDB
+// Setup initializes connections to the InfluxDB instance of every tenant
 func (a *ElectricityAggregator) Setup() error {
-	log.Printf("[Electricity] Connecting to InfluxDB at %s", a.influxURL)
-	log.Printf("[Electricity] Reading from bucket: %s, writing to bucket: %s", a.sourceBucket, a.targetBucket)
-	
-	a.influxClient = influxdb2.NewClient(a.influxURL, a.influxToken)
-	a.queryAPI = a.influxClient.QueryAPI(a.influxOrg)
-	a.writeAPI = a.influxClient.WriteAPIBlocking(a.influxOrg, a.targetBucket)
-	
+	defaultTenant := Tenant{
+		URL:          a.influxURL,
+		Org:          a.influxOrg,
+		Token:        a.influxToken,
+		SourceBucket: a.sourceBucket,
+		TargetBucket: a.targetBucket,
+	}
+	tenants, err := LoadTenants(defaultTenant, a.tenantRoutesFile)
+	if err != nil {
+		return fmt.Errorf("[Electricity] %w", err)
+	}
+
+	for _, tenant := range tenants {
+		log.Printf("[Electricity] Connecting to InfluxDB at %s for tenant %s", tenant.URL, tenant.Name)
+		log.Printf("[Electricity] Reading from bucket: %s, writing to bucket: %s", tenant.SourceBucket, tenant.TargetBucket)
+		a.tenants = append(a.tenants, NewTenantClient(tenant))
+	}
+
 	log.Println("[Electricity] Aggregator setup complete")
 	return nil
 }
@@ -95,11 +118,34 @@ func (a *ElectricityAggregator) Run() error {
 		}
 	}
 }
-// RunAggregation performs one electricity aggregation cycle
+// RunAggregation performs one electricity aggregation cycle for every tenant
 func (a *ElectricityAggregator) RunAggregation() {
-	log.Println("[Electricity] Starting aggregation...")
+	start := time.Now()
+	succeeded := true
+	for _, tenant := range a.tenants {
+		if !a.aggregateTenant(tenant) {
+			succeeded = false
+		}
+	}
+	recordRun(a.Name(), start, succeeded)
+}
+
+// aggregateTenant aggregates the electricity readings of one tenant and reports whether
+// every query and write succeeded
+func (a *ElectricityAggregator) aggregateTenant(tenant *TenantClient) bool {
+	log.Printf("[Electricity] Starting aggregation for tenant %s...", tenant.Name)
+
+	// Create the tenant's aggregated bucket before the first write
+	if err := tenant.EnsureTargetBucket(context.Background()); err != nil {
+		log.Printf("[Electricity] Failed to prepare bucket %s for tenant %s: %v", tenant.TargetBucket, tenant.Name, err)
+		return false
+	}
 
 	sensorType := "electricity"
+	succeeded := true
+
+	// Leave out readings whose quality is not wanted in the aggregates
+	qualityFilter := QualityFilter(a.aggregateQualities)
 
 	aggregationTypes := []string{"mean", "min", "max", "sum", "count"}
 	
@@ -110,21 +156,26 @@ func (a *ElectricityAggregator) RunAggregation() {
 from(bucket: "%s")
   |> range(start: -%s)
   |> filter(fn: (r) => r._measurement == "%s")
+  |> filter(fn: (r) => r._field == "value")
+  %s
   |> group(columns: ["sensorId", "location"])
   |> aggregateWindow(every: %s, fn: %s, createEmpty: false)
   |> yield(name: "%s")
-`, a.sourceBucket, a.aggregationInterval, sensorType, a.aggregationInterval, aggType, aggType)
+`, tenant.SourceBucket, a.aggregationInterval, sensorType, qualityFilter, a.aggregationInterval, aggType, aggType)
 
 		// Execute the query
-		result, err := a.queryAPI.Query(context.Background(), flux)
+		result, err := tenant.queryAPI.Query(context.Background(), flux)
 		if err != nil {
 			log.Printf("[Electricity] Query error for %s: %v", aggType, err)
+			queryErrors.WithLabelValues(sensorType).Inc()
+			succeeded = false
 			continue
 		}
 
 		// Process and store the aggregated results
 		for result.Next() {
 			record := result.Record()
+			rowsRead.WithLabelValues(sensorType).Inc()
 			
 			value := record.Value()
 
@@ -158,10 +209,13 @@ from(bucket: "%s")
 				timestamp,
 			)
 
-			err := a.writeAPI.WritePoint(context.Background(), point)
+			err := tenant.writeAPI.WritePoint(context.Background(), point)
 			if err != nil {
 				log.Printf("[Electricity] Write error: %v", err)
+				writeErrors.WithLabelValues(sensorType).Inc()
+				succeeded = false
 			} else {
+				pointsWritten.WithLabelValues(sensorType).Inc()
 				log.Printf("[Electricity] Wrote aggregated point (%s) for sensor %s at %s: %v", 
 					aggType, sensorID, timestamp, value)
 			}
@@ -169,16 +223,29 @@ from(bucket: "%s")
 
 		if result.Err() != nil {
 			log.Printf("[Electricity] Query parsing error for %s: %v", aggType, result.Err())
+			queryErrors.WithLabelValues(sensorType).Inc()
+			succeeded = false
 		}
 	}
+
+	return succeeded
+}
+
+// Ready reports whether the aggregator ran recently and can reach every tenant's buckets
+func (a *ElectricityAggregator) Ready(ctx context.Context) error {
+	if err := checkLastRun(a.Name(), a.aggregationInterval, a.readyMaxRunAge); err != nil {
+		return err
+	}
+	// Setup has filled in the tenants once a run was recorded
+	return checkTenants(ctx, a.tenants)
 }
 
 // Shutdown performs a graceful shutdown
 func (a *ElectricityAggregator) Shutdown() {
 	log.Println("[Electricity] Shutting down aggregator service...")
 
-	if a.influxClient != nil {
-		a.influxClient.Close()
+	for _, tenant := range a.tenants {
+		tenant.Close()
 	}
 
 	log.Println("[Electricity] Aggregator service shutdown complete")
@@ -2,12 +2,22 @@ module github.com/lockedinskage/processor
 
 go 1.21
 
-require github.com/influxdata/influxdb-client-go/v2 v2.13.0
+require (
+	github.com/influxdata/influxdb-client-go/v2 v2.13.0
+	github.com/prometheus/client_golang v1.19.1
+)
 
 require (
 	github.com/apapsch/go-jsonmerge/v2 v2.0.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/influxdata/line-protocol v0.0.0-20210922203350-b1ad95c89adf // indirect
 	github.com/oapi-codegen/runtime v1.1.0 // indirect
-	golang.org/x/net v0.17.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
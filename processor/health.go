@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// healthCheckTimeout bounds the dependency checks of a readiness probe
+const healthCheckTimeout = 5 * time.Second
+
+// HealthResponse is the body of /healthz and /readyz
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// checkLastRun reports whether an aggregator completed a run within maxAge, or within
+// twice its aggregation interval when maxAge is zero
+func checkLastRun(aggregator string, aggregationInterval string, maxAge time.Duration) error {
+	last, ok := lastSuccess.Last(aggregator)
+	if !ok {
+		return fmt.Errorf("no successful aggregation run yet")
+	}
+	if maxAge <= 0 {
+		interval, err := time.ParseDuration(aggregationInterval)
+		if err != nil {
+			return fmt.Errorf("invalid aggregation interval: %w", err)
+		}
+		maxAge = 2 * interval
+	}
+	if age := time.Since(last); age > maxAge {
+		return fmt.Errorf("last successful aggregation run %s ago", age.Round(time.Second))
+	}
+	return nil
+}
+
+// checkTenants reports whether the InfluxDB instance and buckets of every tenant can be reached
+func checkTenants(ctx context.Context, tenants []*TenantClient) error {
+	for _, tenant := range tenants {
+		if err := tenant.Check(ctx); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant.Name, err)
+		}
+	}
+	return nil
+}
+
+// handleHealthz reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// readyzHandler reports whether every aggregator is ready
+func readyzHandler(aggregators []BaseAggregator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+		defer cancel()
+
+		response := HealthResponse{Status: "ok", Checks: make(map[string]string, len(aggregators))}
+		status := http.StatusOK
+		for _, aggregator := range aggregators {
+			response.Checks[aggregator.Name()] = "ok"
+			if err := aggregator.Ready(ctx); err != nil {
+				response.Checks[aggregator.Name()] = err.Error()
+				response.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, status, response)
+	}
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 )
 
 // HumidityAggregator handles aggregating humidity data in InfluxDB
@@ -19,13 +18,18 @@ type HumidityAggregator struct {
 	sourceBucket string
 	targetBucket string
 
+	// Tenant configuration
+	tenantRoutesFile string
+
 	// Aggregation configuration
 	aggregationInterval string
+	aggregateQualities  string
+
+	// Health configuration
+	readyMaxRunAge time.Duration
 
-	// Clients
-	influxClient influxdb2.Client
-	queryAPI     api.QueryAPI
-	writeAPI     api.WriteAPIBlocking
+	// Clients, one per tenant
+	tenants []*TenantClient
 
 	// For graceful shutdown
 	ctx        context.Context
@@ -42,25 +46,44 @@ func NewHumidityAggregator(config *Config) *HumidityAggregator {
 		influxOrg:           config.InfluxOrg,
 		sourceBucket:        config.SourceBucket,
 		targetBucket:        config.TargetBucket,
+		tenantRoutesFile:    config.TenantRoutesFile,
 		aggregationInterval: config.AggregationInterval,
+		aggregateQualities:  config.AggregateQualities,
+		readyMaxRunAge:      config.ReadyMaxRunAge,
 		ctx:                 ctx,
 		cancelFunc:          cancel,
 	}
 }
 
+// Name returns the sensor type this aggregator handles
+func (a *HumidityAggregator) Name() string {
+	return "humidity"
+}
+
 // GetCancelFunc returns the cancel function for this aggregator
 func (a *HumidityAggregator) GetCancelFunc() context.CancelFunc {
 	return a.cancelFunc
 }
 
-// Setup initializes connections to InfluxDB
+// Setup initializes connections to the InfluxDB instance of every tenant
 func (a *HumidityAggregator) Setup() error {
-	log.Printf("[Humidity] Connecting to InfluxDB at %s", a.influxURL)
-	log.Printf("[Humidity] Reading from bucket: %s, writing to bucket: %s", a.sourceBucket, a.targetBucket)
+	defaultTenant := Tenant{
+		URL:          a.influxURL,
+		Org:          a.influxOrg,
+		Token:        a.influxToken,
+		SourceBucket: a.sourceBucket,
+		TargetBucket: a.targetBucket,
+	}
+	tenants, err := LoadTenants(defaultTenant, a.tenantRoutesFile)
+	if err != nil {
+		return fmt.Errorf("[Humidity] %w", err)
+	}
 
-	a.influxClient = influxdb2.NewClient(a.influxURL, a.influxToken)
-	a.queryAPI = a.influxClient.QueryAPI(a.influxOrg)
-	a.writeAPI = a.influxClient.WriteAPIBlocking(a.influxOrg, a.targetBucket)
+	for _, tenant := range tenants {
+		log.Printf("[Humidity] Connecting to InfluxDB at %s for tenant %s", tenant.URL, tenant.Name)
+		log.Printf("[Humidity] Reading from bucket: %s, writing to bucket: %s", tenant.SourceBucket, tenant.TargetBucket)
+		a.tenants = append(a.tenants, NewTenantClient(tenant))
+	}
 
 	log.Println("[Humidity] Aggregator setup complete")
 	return nil
@@ -96,12 +119,35 @@ func (a *HumidityAggregator) Run() error {
 	}
 }
 
-// RunAggregation performs one humidity aggregation cycle
+// RunAggregation performs one humidity aggregation cycle for every tenant
 func (a *HumidityAggregator) RunAggregation() {
-	log.Println("[Humidity] Starting aggregation...")
+	start := time.Now()
+	succeeded := true
+	for _, tenant := range a.tenants {
+		if !a.aggregateTenant(tenant) {
+			succeeded = false
+		}
+	}
+	recordRun(a.Name(), start, succeeded)
+}
+
+// aggregateTenant aggregates the humidity readings of one tenant and reports whether
+// every query and write succeeded
+func (a *HumidityAggregator) aggregateTenant(tenant *TenantClient) bool {
+	log.Printf("[Humidity] Starting aggregation for tenant %s...", tenant.Name)
+
+	// Create the tenant's aggregated bucket before the first write
+	if err := tenant.EnsureTargetBucket(context.Background()); err != nil {
+		log.Printf("[Humidity] Failed to prepare bucket %s for tenant %s: %v", tenant.TargetBucket, tenant.Name, err)
+		return false
+	}
 
 	// Set sensor type for this aggregator
 	sensorType := "humidity"
+	succeeded := true
+
+	// Leave out readings whose quality is not wanted in the aggregates
+	qualityFilter := QualityFilter(a.aggregateQualities)
 
 	// Define the aggregation types to run
 	aggregationTypes := []string{"mean", "min", "max", "count"}
@@ -113,21 +159,26 @@ func (a *HumidityAggregator) RunAggregation() {
 from(bucket: "%s")
   |> range(start: -%s)
   |> filter(fn: (r) => r._measurement == "%s")
+  |> filter(fn: (r) => r._field == "value")
+  %s
   |> group(columns: ["sensorId", "location"])
   |> aggregateWindow(every: %s, fn: %s, createEmpty: false)
   |> yield(name: "%s")
-`, a.sourceBucket, a.aggregationInterval, sensorType, a.aggregationInterval, aggType, aggType)
+`, tenant.SourceBucket, a.aggregationInterval, sensorType, qualityFilter, a.aggregationInterval, aggType, aggType)
 
 		// Execute the query
-		result, err := a.queryAPI.Query(context.Background(), flux)
+		result, err := tenant.queryAPI.Query(context.Background(), flux)
 		if err != nil {
 			log.Printf("[Humidity] Query error for %s: %v", aggType, err)
+			queryErrors.WithLabelValues(sensorType).Inc()
+			succeeded = false
 			continue
 		}
 
 		// Process and store the aggregated results
 		for result.Next() {
 			record := result.Record()
+			rowsRead.WithLabelValues(sensorType).Inc()
 
 			value := record.Value()
 
@@ -160,10 +211,13 @@ from(bucket: "%s")
 				timestamp,
 			)
 
-			err := a.writeAPI.WritePoint(context.Background(), point)
+			err := tenant.writeAPI.WritePoint(context.Background(), point)
 			if err != nil {
 				log.Printf("[Humidity] Write error: %v", err)
+				writeErrors.WithLabelValues(sensorType).Inc()
+				succeeded = false
 			} else {
+				pointsWritten.WithLabelValues(sensorType).Inc()
 				log.Printf("[Humidity] Wrote aggregated point (%s) for sensor %s at %s: %v",
 					aggType, sensorID, timestamp, value)
 			}
@@ -171,16 +225,29 @@ from(bucket: "%s")
 
 		if result.Err() != nil {
 			log.Printf("[Humidity] Query parsing error for %s: %v", aggType, result.Err())
+			queryErrors.WithLabelValues(sensorType).Inc()
+			succeeded = false
 		}
 	}
+
+	return succeeded
+}
+
+// Ready reports whether the aggregator ran recently and can reach every tenant's buckets
+func (a *HumidityAggregator) Ready(ctx context.Context) error {
+	if err := checkLastRun(a.Name(), a.aggregationInterval, a.readyMaxRunAge); err != nil {
+		return err
+	}
+	// Setup has filled in the tenants once a run was recorded
+	return checkTenants(ctx, a.tenants)
 }
 
 // Shutdown performs a graceful shutdown
 func (a *HumidityAggregator) Shutdown() {
 	log.Println("[Humidity] Shutting down aggregator service...")
 
-	if a.influxClient != nil {
-		a.influxClient.Close()
+	for _, tenant := range a.tenants {
+		tenant.Close()
 	}
 
 	log.Println("[Humidity] Aggregator service shutdown complete")
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 )
 
 func main() {
@@ -19,6 +22,12 @@ func main() {
 	factory := &AggregatorFactory{}
 	aggregators := factory.CreateAggregators(config)
 
+	// Serve metrics and health checks
+	var server *http.Server
+	if config.HTTPAddr != "" {
+		server = startHTTPServer(config.HTTPAddr, aggregators)
+	}
+
 	// Run aggregators in goroutines
 	for _, agg := range aggregators {
 		aggregator := agg // Create a local copy for the closure
@@ -38,4 +47,12 @@ func main() {
 		aggregator.GetCancelFunc()()
 		aggregator.Shutdown()
 	}
+
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to stop HTTP server: %v", err)
+		}
+	}
 }
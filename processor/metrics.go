@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics served on /metrics, labelled with the aggregator's sensor type
+var (
+	aggregationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "processor_aggregation_duration_seconds",
+		Help:    "Duration of aggregation runs over all tenants.",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"aggregator"})
+
+	rowsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "processor_rows_read_total",
+		Help: "Aggregated rows read from InfluxDB.",
+	}, []string{"aggregator"})
+
+	pointsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "processor_points_written_total",
+		Help: "Aggregated points written to InfluxDB.",
+	}, []string{"aggregator"})
+
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "processor_query_errors_total",
+		Help: "Flux queries that failed or returned an error while being read.",
+	}, []string{"aggregator"})
+
+	writeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "processor_write_errors_total",
+		Help: "Aggregated points that could not be written.",
+	}, []string{"aggregator"})
+
+	lastSuccess = newRunTracker()
+)
+
+// runTracker remembers when each aggregator last completed a run without errors
+type runTracker struct {
+	mu   sync.Mutex
+	runs map[string]time.Time
+
+	sinceDesc     *prometheus.Desc
+	timestampDesc *prometheus.Desc
+}
+
+// newRunTracker creates a tracker and registers it with the default registry
+func newRunTracker() *runTracker {
+	t := &runTracker{
+		runs: make(map[string]time.Time),
+		sinceDesc: prometheus.NewDesc("processor_seconds_since_last_success",
+			"Seconds since the aggregator last completed a run without errors.", []string{"aggregator"}, nil),
+		timestampDesc: prometheus.NewDesc("processor_last_success_timestamp_seconds",
+			"Unix time at which the aggregator last completed a run without errors.", []string{"aggregator"}, nil),
+	}
+	prometheus.MustRegister(t)
+	return t
+}
+
+// Succeeded records a successful run of an aggregator
+func (t *runTracker) Succeeded(aggregator string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.runs[aggregator] = at
+}
+
+// Last returns when an aggregator last succeeded
+func (t *runTracker) Last(aggregator string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	at, ok := t.runs[aggregator]
+	return at, ok
+}
+
+// Describe implements prometheus.Collector
+func (t *runTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- t.sinceDesc
+	ch <- t.timestampDesc
+}
+
+// Collect implements prometheus.Collector
+func (t *runTracker) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for aggregator, at := range t.runs {
+		ch <- prometheus.MustNewConstMetric(t.sinceDesc, prometheus.GaugeValue, now.Sub(at).Seconds(), aggregator)
+		ch <- prometheus.MustNewConstMetric(t.timestampDesc, prometheus.GaugeValue, float64(at.Unix()), aggregator)
+	}
+}
+
+// recordRun records the duration and outcome of an aggregation run started at start
+func recordRun(aggregator string, start time.Time, succeeded bool) {
+	now := time.Now()
+	aggregationDuration.WithLabelValues(aggregator).Observe(now.Sub(start).Seconds())
+	if succeeded {
+		lastSuccess.Succeeded(aggregator, now)
+	}
+}
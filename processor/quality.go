@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// QualityFilter returns a Flux filter that keeps readings whose quality tag is one of the
+// comma-separated qualities, plus readings stored before they were graded. An empty list
+// keeps every reading.
+func QualityFilter(qualities string) string {
+	conditions := []string{"not exists r.quality"}
+	for _, quality := range strings.Split(qualities, ",") {
+		quality = strings.TrimSpace(quality)
+		if quality != "" {
+			conditions = append(conditions, fmt.Sprintf("r.quality == %q", quality))
+		}
+	}
+	if len(conditions) == 1 {
+		return ""
+	}
+	return fmt.Sprintf("|> filter(fn: (r) => %s)", strings.Join(conditions, " or "))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+)
+
+// defaultTenantName names the tenant configured through the INFLUXDB_* variables
+const defaultTenantName = "default"
+
+// Tenant is one customer or building whose readings are aggregated separately
+type Tenant struct {
+	Name         string
+	URL          string
+	Org          string
+	Token        string
+	SourceBucket string
+	TargetBucket string
+}
+
+// tenantRoute is an entry of the routing table shared with the consumer
+type tenantRoute struct {
+	URL              string `json:"url"`
+	Org              string `json:"org"`
+	Token            string `json:"token"`
+	Bucket           string `json:"bucket"`
+	AggregatedBucket string `json:"aggregatedBucket"`
+}
+
+// LoadTenants returns the default tenant followed by the tenants of the routing table at path.
+// Empty route fields fall back to the default tenant; the aggregated bucket defaults to
+// the tenant's bucket with an "_aggregated" suffix.
+func LoadTenants(defaultTenant Tenant, path string) ([]Tenant, error) {
+	defaultTenant.Name = defaultTenantName
+	tenants := []Tenant{defaultTenant}
+	if path == "" {
+		return tenants, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tenant routes: %w", err)
+	}
+	var routes map[string]tenantRoute
+	if err := json.Unmarshal(raw, &routes); err != nil {
+		return nil, fmt.Errorf("failed to parse tenant routes %s: %w", path, err)
+	}
+
+	names := make([]string, 0, len(routes))
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		route := routes[name]
+		if route.Bucket == "" {
+			return nil, fmt.Errorf("tenant %q has no bucket", name)
+		}
+
+		tenant := defaultTenant
+		tenant.Name = name
+		tenant.SourceBucket = route.Bucket
+		tenant.TargetBucket = route.Bucket + "_aggregated"
+		if route.AggregatedBucket != "" {
+			tenant.TargetBucket = route.AggregatedBucket
+		}
+		if route.URL != "" {
+			tenant.URL = route.URL
+		}
+		if route.Org != "" {
+			tenant.Org = route.Org
+		}
+		if route.Token != "" {
+			tenant.Token = route.Token
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, nil
+}
+
+// TenantClient holds the InfluxDB clients of one tenant
+type TenantClient struct {
+	Tenant
+
+	influxClient influxdb2.Client
+	queryAPI     api.QueryAPI
+	writeAPI     api.WriteAPIBlocking
+
+	// Whether the target bucket is known to exist
+	targetMu    sync.Mutex
+	targetReady bool
+}
+
+// NewTenantClient connects to the InfluxDB instance of a tenant
+func NewTenantClient(tenant Tenant) *TenantClient {
+	client := influxdb2.NewClient(tenant.URL, tenant.Token)
+	return &TenantClient{
+		Tenant:       tenant,
+		influxClient: client,
+		queryAPI:     client.QueryAPI(tenant.Org),
+		writeAPI:     client.WriteAPIBlocking(tenant.Org, tenant.TargetBucket),
+	}
+}
+
+// Check pings the tenant's InfluxDB instance and makes sure both of its buckets exist
+func (t *TenantClient) Check(ctx context.Context) error {
+	healthy, err := t.influxClient.Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("InfluxDB unreachable: %w", err)
+	}
+	if !healthy {
+		return fmt.Errorf("InfluxDB is not healthy")
+	}
+	for _, bucket := range []string{t.SourceBucket, t.TargetBucket} {
+		if _, err := t.influxClient.BucketsAPI().FindBucketByName(ctx, bucket); err != nil {
+			return fmt.Errorf("bucket %s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// EnsureTargetBucket creates the tenant's aggregated bucket if it doesn't exist yet. Only the
+// default bucket is created with InfluxDB, so tenants would otherwise need theirs created by hand.
+func (t *TenantClient) EnsureTargetBucket(ctx context.Context) error {
+	t.targetMu.Lock()
+	defer t.targetMu.Unlock()
+	if t.targetReady {
+		return nil
+	}
+
+	buckets := t.influxClient.BucketsAPI()
+	if _, err := buckets.FindBucketByName(ctx, t.TargetBucket); err == nil {
+		t.targetReady = true
+		return nil
+	}
+
+	org, err := t.influxClient.OrganizationsAPI().FindOrganizationByName(ctx, t.Org)
+	if err != nil {
+		return fmt.Errorf("failed to find org %s: %w", t.Org, err)
+	}
+	if _, err := buckets.CreateBucketWithName(ctx, org, t.TargetBucket); err != nil {
+		// Another aggregator may have created it in the meantime
+		if _, findErr := buckets.FindBucketByName(ctx, t.TargetBucket); findErr != nil {
+			return fmt.Errorf("failed to create bucket %s: %w", t.TargetBucket, err)
+		}
+	} else {
+		log.Printf("Created bucket %s for tenant %s", t.TargetBucket, t.Name)
+	}
+	t.targetReady = true
+	return nil
+}
+
+// Close closes the tenant's InfluxDB client
+func (t *TenantClient) Close() {
+	t.influxClient.Close()
+}